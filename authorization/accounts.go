@@ -0,0 +1,407 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownAccount = errors.New("unknown account")
+	ErrNoAccount      = errors.New("no current account")
+)
+
+// Account is a user signed in on this device, identified by the subject of
+// its user info.
+type Account struct {
+	Sub   string `json:"sub"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type AccountList struct {
+	Current  string    `json:"current,omitempty"`
+	Accounts []Account `json:"accounts"`
+}
+
+// AccountIndex persists the account list, the tokens are kept in the
+// per-account token stores.
+type AccountIndex interface {
+	// Load returns an empty list when nothing is saved.
+	Load(ctx context.Context) (*AccountList, error)
+	Save(ctx context.Context, list *AccountList) error
+}
+
+type AccountsOptions struct {
+	// Store returns the token store of the account sub.
+	Store func(sub string) (TokenStore, error)
+	// Index keeps the account list in memory when nil.
+	Index AccountIndex
+}
+
+func (o *AccountsOptions) check() error {
+	if o.Store == nil {
+		return errors.New("store required")
+	}
+
+	return nil
+}
+
+// Accounts manages the tokens of several users sharing one device. Every
+// account gets its own Auth, which works with mqttAuth and apiclient like a
+// single user one. mu is never held during a request to the provider.
+type Accounts struct {
+	cfg  *Config
+	opts AccountsOptions
+
+	mu    sync.Mutex
+	list  AccountList
+	auths map[string]*Auth
+	// authsMux serializes creating, replacing and removing the Auth of an
+	// account, which reach the provider and the token store outside of mu.
+	authsMux sync.Mutex
+}
+
+// NewAccounts loads the account list. cfg is the template of the account
+// configs, its token functions and store are replaced per account.
+func NewAccounts(ctx context.Context, cfg *Config, opts *AccountsOptions) (*Accounts, error) {
+	const op = "lib.authorization.NewAccounts"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	m := &Accounts{
+		cfg:   cfg,
+		opts:  *opts,
+		auths: make(map[string]*Auth),
+	}
+	if m.opts.Index == nil {
+		m.opts.Index = &memoryAccountIndex{}
+	}
+
+	list, err := m.opts.Index.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to load accounts: %w", op, err)
+	}
+	m.list = *list
+
+	return m, nil
+}
+
+func (m *Accounts) List() []Account {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.list.Accounts)
+}
+
+func (m *Accounts) Current() (Account, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.indexDangerously(m.list.Current)
+	if i < 0 {
+		return Account{}, false
+	}
+
+	return m.list.Accounts[i], true
+}
+
+// Add stores the token of auth, e.g. just returned by AuthFlow.Finalize, as
+// the account of its user and makes it current. The returned Auth must be
+// used instead of auth, so refreshed tokens are saved to the account.
+// Adding a user again hands the new token to the Auth already returned for
+// the account, so its users and its background refresh carry on with it.
+func (m *Accounts) Add(ctx context.Context, auth *Auth) (*Auth, error) {
+	const op = "lib.authorization.Accounts.Add"
+
+	if auth.IsClientCredentials() {
+		return nil, fmt.Errorf("%s: client credentials have no user", op)
+	}
+
+	info, err := auth.FetchUserInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to fetch user info: %w", op, err)
+	}
+
+	auth.tokenMux.Lock()
+	token, idToken := auth.token, auth.idToken
+	auth.tokenMux.Unlock()
+	if token == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoToken)
+	}
+
+	m.authsMux.Lock()
+	defer m.authsMux.Unlock()
+
+	m.mu.Lock()
+	accountAuth, ok := m.auths[info.Sub]
+	m.mu.Unlock()
+
+	if ok {
+		if err := accountAuth.replaceToken(ctx, token, idToken); err != nil {
+			return nil, fmt.Errorf("%s: failed to save token: %w", op, err)
+		}
+	} else {
+		cfg, err := m.accountConfig(info.Sub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := cfg.saveTokenIfNeeded(ctx, token); err != nil {
+			return nil, fmt.Errorf("%s: failed to save token: %w", op, err)
+		}
+		accountAuth = &Auth{cfg: cfg, token: token, idToken: idToken}
+	}
+
+	account := Account{
+		Sub:   info.Sub,
+		Name:  strings.TrimSpace(info.Traits.Name.First + " " + info.Traits.Name.Last),
+		Email: info.Traits.Email,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := m.cloneListDangerously()
+	if i := slices.IndexFunc(list.Accounts, func(a Account) bool { return a.Sub == account.Sub }); i >= 0 {
+		list.Accounts[i] = account
+	} else {
+		list.Accounts = append(list.Accounts, account)
+	}
+	list.Current = account.Sub
+
+	if err := m.saveDangerously(ctx, list); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	m.auths[account.Sub] = accountAuth
+
+	return accountAuth, nil
+}
+
+// Auth returns the Auth of the account sub, loading its token on first use.
+func (m *Accounts) Auth(ctx context.Context, sub string) (*Auth, error) {
+	const op = "lib.authorization.Accounts.Auth"
+
+	auth, err := m.auth(ctx, sub)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return auth, nil
+}
+
+// CurrentAuth returns the Auth of the current account, ErrNoAccount when
+// there is none.
+func (m *Accounts) CurrentAuth(ctx context.Context) (*Auth, error) {
+	const op = "lib.authorization.Accounts.CurrentAuth"
+
+	m.mu.Lock()
+	current := m.list.Current
+	m.mu.Unlock()
+
+	if current == "" {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoAccount)
+	}
+
+	auth, err := m.auth(ctx, current)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return auth, nil
+}
+
+// Switch makes sub the current account.
+func (m *Accounts) Switch(ctx context.Context, sub string) error {
+	const op = "lib.authorization.Accounts.Switch"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.indexDangerously(sub) < 0 {
+		return fmt.Errorf("%s: %q: %w", op, sub, ErrUnknownAccount)
+	}
+
+	list := m.cloneListDangerously()
+	list.Current = sub
+
+	if err := m.saveDangerously(ctx, list); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Remove logs the account sub out and forgets it. The first remaining
+// account becomes current if sub was. The account is removed even if the
+// logout fails.
+func (m *Accounts) Remove(ctx context.Context, sub string) error {
+	const op = "lib.authorization.Accounts.Remove"
+
+	m.authsMux.Lock()
+	defer m.authsMux.Unlock()
+
+	m.mu.Lock()
+	known := m.indexDangerously(sub) >= 0
+	m.mu.Unlock()
+
+	if !known {
+		return fmt.Errorf("%s: %q: %w", op, sub, ErrUnknownAccount)
+	}
+
+	var errs []error
+
+	if auth, err := m.authLocked(ctx, sub); err == nil {
+		if err := auth.Logout(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	} else if cfg, err := m.accountConfig(sub); err != nil {
+		errs = append(errs, err)
+	} else if err := cfg.deleteTokenIfNeeded(ctx); err != nil {
+		// the token could not be loaded, it still must not stay behind
+		errs = append(errs, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := m.cloneListDangerously()
+	list.Accounts = slices.DeleteFunc(list.Accounts, func(a Account) bool { return a.Sub == sub })
+	if list.Current == sub {
+		list.Current = ""
+		if len(list.Accounts) > 0 {
+			list.Current = list.Accounts[0].Sub
+		}
+	}
+
+	if err := m.saveDangerously(ctx, list); err != nil {
+		errs = append(errs, err)
+	} else {
+		delete(m.auths, sub)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// auth returns the Auth of sub, loading it if needed.
+func (m *Accounts) auth(ctx context.Context, sub string) (*Auth, error) {
+	m.mu.Lock()
+	auth, ok := m.auths[sub]
+	m.mu.Unlock()
+
+	if ok {
+		return auth, nil
+	}
+
+	m.authsMux.Lock()
+	defer m.authsMux.Unlock()
+
+	return m.authLocked(ctx, sub)
+}
+
+// authLocked is auth with authsMux held. The token is loaded outside of mu,
+// as loading may refresh it.
+func (m *Accounts) authLocked(ctx context.Context, sub string) (*Auth, error) {
+	m.mu.Lock()
+	auth, ok := m.auths[sub]
+	known := m.indexDangerously(sub) >= 0
+	m.mu.Unlock()
+
+	if ok {
+		return auth, nil
+	}
+	if !known {
+		return nil, fmt.Errorf("%q: %w", sub, ErrUnknownAccount)
+	}
+
+	cfg, err := m.accountConfig(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err = Load(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.auths[sub] = auth
+	m.mu.Unlock()
+
+	return auth, nil
+}
+
+// accountConfig copies the template config with the token store of sub.
+func (m *Accounts) accountConfig(sub string) (*Config, error) {
+	store, err := m.opts.Store(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token store: %w", err)
+	}
+
+	cfg := *m.cfg
+	cfg.LoadToken = nil
+	cfg.SaveToken = nil
+	cfg.DeleteToken = nil
+	cfg.Store = store
+
+	return &cfg, nil
+}
+
+func (m *Accounts) indexDangerously(sub string) int {
+	return slices.IndexFunc(m.list.Accounts, func(a Account) bool {
+		return a.Sub == sub
+	})
+}
+
+func (m *Accounts) cloneListDangerously() AccountList {
+	return AccountList{
+		Current:  m.list.Current,
+		Accounts: slices.Clone(m.list.Accounts),
+	}
+}
+
+// saveDangerously saves list and makes it the current one, so a failed save
+// changes nothing.
+func (m *Accounts) saveDangerously(ctx context.Context, list AccountList) error {
+	if err := m.opts.Index.Save(ctx, &list); err != nil {
+		return fmt.Errorf("failed to save accounts: %w", err)
+	}
+	m.list = list
+
+	return nil
+}
+
+type memoryAccountIndex struct {
+	mu   sync.Mutex
+	list AccountList
+}
+
+func (i *memoryAccountIndex) Load(context.Context) (*AccountList, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return &AccountList{
+		Current:  i.list.Current,
+		Accounts: slices.Clone(i.list.Accounts),
+	}, nil
+}
+
+func (i *memoryAccountIndex) Save(_ context.Context, list *AccountList) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.list = AccountList{
+		Current:  list.Current,
+		Accounts: slices.Clone(list.Accounts),
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package authorization
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// accountStores hands out a store per account.
+type accountStores struct {
+	mu     sync.Mutex
+	stores map[string]*store
+}
+
+func (s *accountStores) get(sub string) *store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stores == nil {
+		s.stores = make(map[string]*store)
+	}
+	if s.stores[sub] == nil {
+		s.stores[sub] = &store{}
+	}
+
+	return s.stores[sub]
+}
+
+func (s *accountStores) options(index AccountIndex) *AccountsOptions {
+	return &AccountsOptions{
+		Store: func(sub string) (TokenStore, error) { return s.get(sub), nil },
+		Index: index,
+	}
+}
+
+func newAccounts(t *testing.T, p *provider, stores *accountStores, index AccountIndex) *Accounts {
+	t.Helper()
+
+	m, err := NewAccounts(t.Context(), p.config(nil), stores.options(index))
+	if err != nil {
+		t.Fatalf("NewAccounts: %v", err)
+	}
+
+	return m
+}
+
+// login adds sub as if it just logged in with the token access.
+func login(t *testing.T, p *provider, m *Accounts, sub, access string) *Auth {
+	t.Helper()
+
+	p.mu.Lock()
+	p.sub = sub
+	p.mu.Unlock()
+
+	auth, err := m.Add(t.Context(), &Auth{cfg: p.config(nil), token: &oauth2.Token{
+		AccessToken:  access,
+		RefreshToken: "refresh-" + access,
+		Expiry:       valid(),
+	}})
+	if err != nil {
+		t.Fatalf("Add %s: %v", sub, err)
+	}
+
+	return auth
+}
+
+func subs(accounts []Account) []string {
+	subs := make([]string, 0, len(accounts))
+	for _, a := range accounts {
+		subs = append(subs, a.Sub)
+	}
+
+	return subs
+}
+
+func TestAccounts(t *testing.T) {
+	p := newProvider(t)
+	stores := &accountStores{}
+	m := newAccounts(t, p, stores, nil)
+
+	if _, err := m.CurrentAuth(t.Context()); !errors.Is(err, ErrNoAccount) {
+		t.Errorf("CurrentAuth without accounts error = %v, want %v", err, ErrNoAccount)
+	}
+
+	u1 := login(t, p, m, "u1", "a1")
+	u2 := login(t, p, m, "u2", "a2")
+
+	if got := subs(m.List()); !slices.Equal(got, []string{"u1", "u2"}) {
+		t.Errorf("List = %v, want [u1 u2]", got)
+	}
+	if current, _ := m.Current(); current.Sub != "u2" {
+		t.Errorf("Current = %q, want the last added u2", current.Sub)
+	}
+	if saved := stores.get("u1").saved(); saved == nil || saved.AccessToken != "a1" {
+		t.Errorf("token of u1 saved as %+v", saved)
+	}
+
+	if err := m.Switch(t.Context(), "u1"); err != nil {
+		t.Fatalf("Switch: %v", err)
+	}
+	if auth, err := m.CurrentAuth(t.Context()); err != nil || auth != u1 {
+		t.Errorf("CurrentAuth after Switch = %p, %v, want the Auth of u1 %p", auth, err, u1)
+	}
+	if err := m.Switch(t.Context(), "u3"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Switch to an unknown account error = %v, want %v", err, ErrUnknownAccount)
+	}
+	if _, err := m.Auth(t.Context(), "u3"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Auth of an unknown account error = %v, want %v", err, ErrUnknownAccount)
+	}
+
+	// removing the current account makes the first remaining one current
+	if err := m.Remove(t.Context(), "u1"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if current, _ := m.Current(); current.Sub != "u2" {
+		t.Errorf("Current after Remove = %q, want u2", current.Sub)
+	}
+	if got := subs(m.List()); !slices.Equal(got, []string{"u2"}) {
+		t.Errorf("List after Remove = %v, want [u2]", got)
+	}
+	if _, err := u1.Token(t.Context()); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Token of the removed account error = %v, want %v", err, ErrNoToken)
+	}
+	if stores.get("u1").saved() != nil {
+		t.Error("token of the removed account kept in its store")
+	}
+	if _, err := m.Auth(t.Context(), "u1"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Auth of the removed account error = %v, want %v", err, ErrUnknownAccount)
+	}
+	if err := m.Remove(t.Context(), "u1"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("second Remove error = %v, want %v", err, ErrUnknownAccount)
+	}
+
+	if err := m.Remove(t.Context(), "u2"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, ok := m.Current(); ok {
+		t.Error("current account left after removing every account")
+	}
+	if _, err := u2.Token(t.Context()); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Token of the removed account error = %v, want %v", err, ErrNoToken)
+	}
+}
+
+func TestAccountsLoad(t *testing.T) {
+	p := newProvider(t)
+	stores := &accountStores{}
+	index := &memoryAccountIndex{}
+
+	login(t, p, newAccounts(t, p, stores, index), "u1", "a1")
+
+	// a restart finds the account and loads its saved token once
+	m := newAccounts(t, p, stores, index)
+	first, err := m.CurrentAuth(t.Context())
+	if err != nil {
+		t.Fatalf("CurrentAuth: %v", err)
+	}
+	if token, err := first.Token(t.Context()); err != nil || token != "a1" {
+		t.Errorf("Token = %q, %v, want a1", token, err)
+	}
+	if second, err := m.Auth(t.Context(), "u1"); err != nil || second != first {
+		t.Errorf("Auth = %p, %v, want the loaded Auth %p", second, err, first)
+	}
+}
+
+func TestAccountsAddAgain(t *testing.T) {
+	p := newProvider(t)
+	stores := &accountStores{}
+	m := newAccounts(t, p, stores, nil)
+
+	first := login(t, p, m, "u1", "a1")
+	events := make(chan TokenEvent, 1)
+	first.OnTokenChange(func(e TokenEvent) { events <- e })
+
+	// logging in again must not leave the first Auth refreshing the old
+	// token next to a second one
+	again := login(t, p, m, "u1", "a2")
+	if again != first {
+		t.Fatal("Add of a known user returned another Auth")
+	}
+	if token, err := first.Token(t.Context()); err != nil || token != "a2" {
+		t.Errorf("Token = %q, %v, want the new a2", token, err)
+	}
+	if e := await(t, events, "no event for the new token"); e.AccessToken != "a2" || e.Err != nil {
+		t.Errorf("event = %+v, want a2", e)
+	}
+	if saved := stores.get("u1").saved(); saved == nil || saved.AccessToken != "a2" {
+		t.Errorf("token saved as %+v, want a2", saved)
+	}
+	if got := subs(m.List()); !slices.Equal(got, []string{"u1"}) {
+		t.Errorf("List = %v, want [u1]", got)
+	}
+}
+
+func TestAccountsLoadDoesNotBlock(t *testing.T) {
+	p := newProvider(t)
+	stores := &accountStores{}
+	index := &memoryAccountIndex{list: AccountList{
+		Current:  "u1",
+		Accounts: []Account{{Sub: "u1"}, {Sub: "u2"}},
+	}}
+	stores.get("u1").token = &oauth2.Token{AccessToken: "a1", RefreshToken: "r1", Expiry: expired()}
+	m := newAccounts(t, p, stores, index)
+
+	// loading the expired token refreshes it
+	arrived, release := p.gate("/token")
+	defer release()
+	loaded := async(func() error {
+		_, err := m.CurrentAuth(t.Context())
+		return err
+	})
+	await(t, arrived, "refresh never reached the provider")
+
+	done := async(func() error {
+		if got := subs(m.List()); len(got) != 2 {
+			t.Errorf("List = %v", got)
+		}
+		return m.Switch(t.Context(), "u2")
+	})
+	if err := await(t, done, "Switch blocked by the refresh of another account"); err != nil {
+		t.Errorf("Switch: %v", err)
+	}
+
+	release()
+	if err := await(t, loaded, "CurrentAuth never returned"); err != nil {
+		t.Errorf("CurrentAuth: %v", err)
+	}
+}
+
+func TestAccountsRemoveDoesNotBlock(t *testing.T) {
+	p := newProvider(t)
+	m := newAccounts(t, p, &accountStores{}, nil)
+	login(t, p, m, "u1", "a1")
+	login(t, p, m, "u2", "a2")
+
+	arrived, release := p.gate("/revoke")
+	defer release()
+	removed := async(func() error { return m.Remove(t.Context(), "u1") })
+	await(t, arrived, "revocation never reached the provider")
+
+	done := async(func() error {
+		_, err := m.CurrentAuth(t.Context())
+		return err
+	})
+	if err := await(t, done, "CurrentAuth blocked by the revocation"); err != nil {
+		t.Errorf("CurrentAuth: %v", err)
+	}
+
+	release()
+	if err := await(t, removed, "Remove never returned"); err != nil {
+		t.Errorf("Remove: %v", err)
+	}
+}
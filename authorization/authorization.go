@@ -2,11 +2,9 @@ package authorization
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"sync"
+	"time"
 
 	"github.com/MaxRomanov007/smart-pc-go-lib/domain/models/user"
 	"golang.org/x/oauth2"
@@ -14,10 +12,29 @@ import (
 
 // Auth manages OAuth2 authentication and token lifecycle.
 // It provides thread-safe access to tokens and handles automatic token refresh.
+// tokenMux is never held during a request to the provider or the store.
 type Auth struct {
 	cfg      *Config
 	token    *oauth2.Token
+	idToken  *IDToken
 	tokenMux sync.Mutex
+	// refresh replaces the refresh token grant, e.g. for client credentials.
+	refresh func(context.Context, *oauth2.Token) (*oauth2.Token, error)
+	// inflight is the refresh in progress, guarded by tokenMux.
+	inflight *refreshCall
+	// tokenGen counts the changes of token, guarded by tokenMux.
+	tokenGen int
+	// storeMux orders saving and deleting the token, which happen outside
+	// of tokenMux.
+	storeMux sync.Mutex
+
+	userInfo    *user.Info
+	userInfoAt  time.Time
+	userInfoGen int
+
+	handlers      map[int]TokenHandler
+	nextHandlerID int
+	handlersMux   sync.Mutex
 }
 
 // Load creates an Auth instance using a previously saved token.
@@ -34,68 +51,113 @@ func Load(ctx context.Context, cfg *Config) (*Auth, error) {
 }
 
 // Token retrieves the current access token, refreshing it if necessary.
+// Concurrent callers share one refresh, each waiting until its own ctx is
+// done at most.
 func (a *Auth) Token(ctx context.Context) (string, error) {
-	a.tokenMux.Lock()
-	defer a.tokenMux.Unlock()
+	token, err := a.oauth2Token(ctx)
+	if err != nil {
+		return "", err
+	}
 
-	return a.tokenDangerously(ctx)
+	return token.AccessToken, nil
 }
 
-// TryToken attempts to retrieve the current access token without blocking.
-// Returns ErrTokenLocked if the token is currently locked by another goroutine.
-func (a *Auth) TryToken(ctx context.Context) (string, error) {
-	if !a.tokenMux.TryLock() {
-		return "", ErrTokenLocked
-	}
-	defer a.tokenMux.Unlock()
+// TokenSource returns a source of the tokens of a, for oauth2.NewClient.
+// Refreshed tokens are saved like with Token.
+func (a *Auth) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return &tokenSource{ctx: ctx, auth: a}
+}
 
-	return a.tokenDangerously(ctx)
+type tokenSource struct {
+	ctx  context.Context
+	auth *Auth
 }
 
-func (a *Auth) tokenDangerously(ctx context.Context) (string, error) {
-	const op = "lib.authorization.tokenDangerously"
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	return s.auth.oauth2Token(s.ctx)
+}
 
-	if a.token == nil {
-		return "", ErrNoToken
-	}
+func (a *Auth) oauth2Token(ctx context.Context) (*oauth2.Token, error) {
+	const op = "lib.authorization.Token"
 
-	if a.token.Valid() {
-		return a.token.AccessToken, nil
+	a.tokenMux.Lock()
+	token, call, err := a.tokenDangerously(ctx)
+	a.tokenMux.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if call == nil {
+		return token, nil
 	}
 
-	token, err := a.cfg.refreshToken(ctx, a.token)
+	token, err = call.wait(ctx)
 	if err != nil {
-		return "", fmt.Errorf("%s: failed to refresh token: %w", op, err)
+		return nil, fmt.Errorf("%s: failed to refresh token: %w", op, err)
 	}
 
-	a.token = token
-	return a.token.AccessToken, nil
+	return token, nil
 }
 
-func (a *Auth) FetchUserInfo(ctx context.Context) (*user.Info, error) {
-	const op = "lib.authorization.FetchUserInfo"
-
-	client := a.cfg.Oauth2Config.Client(ctx, a.token)
+// TryToken retrieves the current access token without waiting for a refresh.
+// Returns ErrTokenLocked while the token is being refreshed, the refresh is
+// started if needed.
+func (a *Auth) TryToken(ctx context.Context) (string, error) {
+	a.tokenMux.Lock()
+	token, call, err := a.tokenDangerously(ctx)
+	a.tokenMux.Unlock()
 
-	resp, err := client.Get(a.cfg.UserInfoURL)
 	if err != nil {
-		return nil, fmt.Errorf("%s: failed to get user info: %w", op, err)
+		return "", err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%s: userinfo request failed, status: %s", op, resp.Status)
+	if call != nil {
+		return "", ErrTokenLocked
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("%s: failed to read body: %w", op, err)
+	return token.AccessToken, nil
+}
+
+// tokenDangerously returns the token if it is still valid, otherwise the
+// refresh to wait for.
+func (a *Auth) tokenDangerously(ctx context.Context) (*oauth2.Token, *refreshCall, error) {
+	if a.token == nil {
+		return nil, nil, ErrNoToken
 	}
 
-	info := new(user.Info)
-	if err := json.Unmarshal(body, info); err != nil {
-		return nil, fmt.Errorf("%s: failed to unmarshal user info: %w", op, err)
+	if a.token.Valid() {
+		return a.token, nil, nil
 	}
 
-	return info, nil
+	return nil, a.refreshDangerously(ctx), nil
+}
+
+// IDToken returns the verified ID token received when logging in, nil when
+// the token was loaded or the config was not created from an issuer.
+func (a *Auth) IDToken() *IDToken {
+	a.tokenMux.Lock()
+	defer a.tokenMux.Unlock()
+
+	return a.idToken
+}
+
+// replaceToken makes token the token of a and saves it, e.g. when the user
+// logs in again. A refresh of the old token in flight is dropped.
+func (a *Auth) replaceToken(ctx context.Context, token *oauth2.Token, idToken *IDToken) error {
+	a.tokenMux.Lock()
+	a.token = token
+	a.idToken = idToken
+	a.tokenGen++
+	gen := a.tokenGen
+	a.invalidateUserInfoDangerously()
+	a.tokenMux.Unlock()
+
+	err := a.saveToken(ctx, gen, token)
+	a.emit(TokenEvent{
+		AccessToken: token.AccessToken,
+		Expiry:      token.Expiry,
+		Err:         err,
+		At:          time.Now(),
+	})
+
+	return err
 }
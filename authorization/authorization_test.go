@@ -0,0 +1,334 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/domain/models/user"
+	"golang.org/x/oauth2"
+)
+
+const timeout = 5 * time.Second
+
+// provider is a fake OAuth2 provider serving the token, revocation and
+// userinfo endpoints.
+type provider struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	issued   int
+	sub      string
+	tokenErr string
+	// gates hold the responses of an endpoint until closed, see gate
+	gates    map[string]chan struct{}
+	requests map[string]int
+	revoked  []string
+	grants   []string
+	// bearers are the Authorization headers of the userinfo requests
+	bearers []string
+	// revokedBy is how the client authenticated each revocation
+	revokedBy    []string
+	revokeStatus int
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+
+	p := &provider{
+		sub:      "u1",
+		gates:    make(map[string]chan struct{}),
+		requests: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", p.handleToken)
+	mux.HandleFunc("/revoke", p.handleRevoke)
+	mux.HandleFunc("/userinfo", p.handleUserInfo)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// gate holds the responses of path until the returned function is called,
+// arrived is signalled for every request held.
+func (p *provider) gate(path string) (arrived <-chan struct{}, release func()) {
+	gate := make(chan struct{})
+	ch := make(chan struct{}, 16)
+
+	p.mu.Lock()
+	p.gates[path] = gate
+	p.gates[path+"#arrived"] = ch
+	p.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() { once.Do(func() { close(gate) }) }
+}
+
+func (p *provider) hold(path string) {
+	p.mu.Lock()
+	p.requests[path]++
+	gate, arrived := p.gates[path], p.gates[path+"#arrived"]
+	p.mu.Unlock()
+
+	if gate == nil {
+		return
+	}
+	arrived <- struct{}{}
+	<-gate
+}
+
+func (p *provider) count(path string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.requests[path]
+}
+
+func (p *provider) setTokenError(code string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tokenErr = code
+}
+
+func (p *provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	p.hold("/token")
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	p.grants = append(p.grants, r.PostForm.Get("grant_type"))
+	tokenErr := p.tokenErr
+	p.issued++
+	issued := p.issued
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if tokenErr != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": tokenErr})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  fmt.Sprintf("access-%d", issued),
+		"refresh_token": fmt.Sprintf("refresh-%d", issued),
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (p *provider) setRevokeStatus(status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.revokeStatus = status
+}
+
+func (p *provider) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	p.hold("/revoke")
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	by := "form:" + r.PostForm.Get("client_id") + ":" + r.PostForm.Get("client_secret")
+	if id, secret, ok := r.BasicAuth(); ok {
+		by = "basic:" + id + ":" + secret
+	}
+
+	p.mu.Lock()
+	p.revoked = append(p.revoked, r.PostForm.Get("token_type_hint")+":"+r.PostForm.Get("token"))
+	p.revokedBy = append(p.revokedBy, by)
+	status := p.revokeStatus
+	p.mu.Unlock()
+
+	if status != 0 {
+		http.Error(w, `{"error":"invalid_request"}`, status)
+	}
+}
+
+func (p *provider) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	// answers for the user at the time of the request
+	p.mu.Lock()
+	sub := p.sub
+	p.bearers = append(p.bearers, r.Header.Get("Authorization"))
+	p.mu.Unlock()
+
+	p.hold("/userinfo")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(user.Info{Sub: sub})
+}
+
+// store is a token store recording the saved token.
+type store struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+	saves int
+	// saving, when set, is called before a save completes
+	saving func()
+}
+
+func (s *store) Load(context.Context) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return nil, ErrNoToken
+	}
+	token := *s.token
+	return &token, nil
+}
+
+func (s *store) Save(_ context.Context, token *oauth2.Token) error {
+	s.mu.Lock()
+	saving := s.saving
+	s.mu.Unlock()
+
+	if saving != nil {
+		saving()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := *token
+	s.token = &t
+	s.saves++
+	return nil
+}
+
+func (s *store) Delete(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = nil
+	return nil
+}
+
+func (s *store) saved() *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token
+}
+
+func (p *provider) config(s *store) *Config {
+	return &Config{
+		Oauth2Config: &oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   p.URL + "/auth",
+				TokenURL:  p.URL + "/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		Store:         s,
+		UserInfoURL:   p.URL + "/userinfo",
+		RevocationURL: p.URL + "/revoke",
+	}
+}
+
+// newAuth returns an Auth holding token, expired when expiry is in the past.
+func newAuth(cfg *Config, expiry time.Time) *Auth {
+	return &Auth{cfg: cfg, token: &oauth2.Token{
+		AccessToken:  "access-0",
+		RefreshToken: "refresh-0",
+		TokenType:    "Bearer",
+		Expiry:       expiry,
+	}}
+}
+
+func expired() time.Time {
+	return time.Now().Add(-time.Hour)
+}
+
+func valid() time.Time {
+	return time.Now().Add(time.Hour)
+}
+
+// await fails the test when ch is not signalled in time.
+func await[T any](t *testing.T, ch <-chan T, msg string) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(timeout):
+		t.Fatal(msg)
+		var zero T
+		return zero
+	}
+}
+
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// async runs f in a goroutine and returns the channel of its error.
+func async(f func() error) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+	return done
+}
+
+func TestLoad(t *testing.T) {
+	p := newProvider(t)
+
+	tests := []struct {
+		name   string
+		token  *oauth2.Token
+		want   string
+		saves  int
+		errNil bool
+	}{
+		{"valid", &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: valid()}, "a", 0, true},
+		{"expired", &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: expired()}, "access-1", 1, true},
+		{"missing", nil, "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &store{token: tt.token}
+			auth, err := Load(t.Context(), p.config(s))
+			if tt.errNil != (err == nil) {
+				t.Fatalf("Load error = %v", err)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrNoToken) {
+					t.Errorf("Load error = %v, want %v", err, ErrNoToken)
+				}
+				return
+			}
+
+			token, err := auth.Token(t.Context())
+			if err != nil || token != tt.want {
+				t.Errorf("Token = %q, %v, want %q", token, err, tt.want)
+			}
+			if s.saves != tt.saves {
+				t.Errorf("%d saves, want %d", s.saves, tt.saves)
+			}
+		})
+	}
+}
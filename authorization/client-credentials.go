@@ -0,0 +1,78 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// NewClientCredentials creates an Auth for a service authenticating as
+// itself with the OAuth2 client credentials grant, using the client id,
+// secret, token url and scopes of cfg.Oauth2Config. A new token is requested
+// shortly before the current one expires; tokens are not saved.
+func NewClientCredentials(ctx context.Context, cfg *Config) (*Auth, error) {
+	const op = "lib.authorization.NewClientCredentials"
+
+	if err := cfg.validateClientCredentials(); err != nil {
+		return nil, fmt.Errorf("%s: invalid config: %w", op, err)
+	}
+
+	a := &Auth{cfg: cfg, refresh: cfg.clientCredentialsToken}
+
+	token, err := cfg.clientCredentialsToken(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	a.token = token
+
+	return a, nil
+}
+
+// IsClientCredentials reports whether a authenticates a service rather than
+// a user, so there is no user info to fetch.
+func (a *Auth) IsClientCredentials() bool {
+	return a.refresh != nil
+}
+
+// ClientID returns the OAuth2 client id, the identity of a service Auth.
+func (a *Auth) ClientID() string {
+	return a.cfg.Oauth2Config.ClientID
+}
+
+func (cfg *Config) clientCredentialsToken(ctx context.Context, _ *oauth2.Token) (*oauth2.Token, error) {
+	const op = "lib.authorization.config.clientCredentialsToken"
+
+	ccConfig := &clientcredentials.Config{
+		ClientID:     cfg.Oauth2Config.ClientID,
+		ClientSecret: cfg.Oauth2Config.ClientSecret,
+		TokenURL:     cfg.Oauth2Config.Endpoint.TokenURL,
+		Scopes:       cfg.Oauth2Config.Scopes,
+		AuthStyle:    cfg.Oauth2Config.Endpoint.AuthStyle,
+	}
+
+	token, err := ccConfig.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to get token: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (cfg *Config) validateClientCredentials() error {
+	var errs []error
+
+	if cfg.Oauth2Config.ClientID == "" {
+		errs = append(errs, errors.New("missing client id"))
+	}
+	if cfg.Oauth2Config.ClientSecret == "" {
+		errs = append(errs, errors.New("missing client secret"))
+	}
+	if cfg.Oauth2Config.Endpoint.TokenURL == "" {
+		errs = append(errs, errors.New("missing token url"))
+	}
+
+	return errors.Join(errs...)
+}
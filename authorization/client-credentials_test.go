@@ -0,0 +1,103 @@
+package authorization
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func clientCredentialsConfig(p *provider, s *store) *Config {
+	cfg := p.config(s)
+	cfg.Oauth2Config.ClientSecret = "s3cret"
+
+	return cfg
+}
+
+// expire makes the token of a expired, as if time went by.
+func expire(a *Auth) {
+	a.tokenMux.Lock()
+	defer a.tokenMux.Unlock()
+
+	token := *a.token
+	token.Expiry = expired()
+	a.token = &token
+}
+
+func TestNewClientCredentials(t *testing.T) {
+	p := newProvider(t)
+	s := &store{}
+
+	auth, err := NewClientCredentials(t.Context(), clientCredentialsConfig(p, s))
+	if err != nil {
+		t.Fatalf("NewClientCredentials: %v", err)
+	}
+	if !auth.IsClientCredentials() || auth.ClientID() != "client" {
+		t.Errorf("IsClientCredentials = %v, ClientID = %q", auth.IsClientCredentials(), auth.ClientID())
+	}
+	if token, err := auth.Token(t.Context()); err != nil || token != "access-1" {
+		t.Errorf("Token = %q, %v, want access-1", token, err)
+	}
+
+	events := make(chan TokenEvent, 1)
+	auth.OnTokenChange(func(e TokenEvent) { events <- e })
+
+	// an expired token is replaced with the client credentials grant again
+	expire(auth)
+	if token, err := auth.Token(t.Context()); err != nil || token != "access-2" {
+		t.Errorf("Token after expiry = %q, %v, want access-2", token, err)
+	}
+	if e := await(t, events, "no token event"); e.AccessToken != "access-2" {
+		t.Errorf("event = %+v, want access-2", e)
+	}
+
+	p.mu.Lock()
+	grants := p.grants
+	p.mu.Unlock()
+	if want := []string{"client_credentials", "client_credentials"}; !slices.Equal(grants, want) {
+		t.Errorf("grants = %v, want %v", grants, want)
+	}
+	if s.saves != 0 {
+		t.Errorf("client credentials token saved %d times", s.saves)
+	}
+
+	accounts, err := NewAccounts(t.Context(), p.config(nil), (&accountStores{}).options(nil))
+	if err != nil {
+		t.Fatalf("NewAccounts: %v", err)
+	}
+	if _, err := accounts.Add(t.Context(), auth); err == nil {
+		t.Error("Add accepted a service as an account")
+	}
+}
+
+func TestNewClientCredentialsInvalid(t *testing.T) {
+	p := newProvider(t)
+
+	cfg := p.config(nil)
+	if _, err := NewClientCredentials(t.Context(), cfg); err == nil {
+		t.Error("NewClientCredentials accepted a config without secret")
+	}
+	if n := p.count("/token"); n != 0 {
+		t.Errorf("%d token requests for an invalid config", n)
+	}
+
+	p.setTokenError("invalid_client")
+	if _, err := NewClientCredentials(t.Context(), clientCredentialsConfig(p, nil)); err == nil {
+		t.Error("NewClientCredentials succeeded with rejected credentials")
+	}
+}
+
+func TestClientCredentialsRevoked(t *testing.T) {
+	p := newProvider(t)
+
+	auth, err := NewClientCredentials(t.Context(), clientCredentialsConfig(p, nil))
+	if err != nil {
+		t.Fatalf("NewClientCredentials: %v", err)
+	}
+
+	// retrying rejected credentials is pointless
+	p.setTokenError("invalid_client")
+	expire(auth)
+	if _, err := auth.Token(t.Context()); !errors.Is(err, ErrReauthRequired) {
+		t.Errorf("Token error = %v, want %v", err, ErrReauthRequired)
+	}
+}
@@ -4,21 +4,44 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"golang.org/x/oauth2"
 )
 
 type (
-	LoadTokenFunc func(context.Context) (*oauth2.Token, error)
-	SaveTokenFunc func(context.Context, *oauth2.Token) error
+	LoadTokenFunc   func(context.Context) (*oauth2.Token, error)
+	SaveTokenFunc   func(context.Context, *oauth2.Token) error
+	DeleteTokenFunc func(context.Context) error
 )
 
+// TokenStore persists the token between runs, see the token-store package
+// for ready-made stores.
+type TokenStore interface {
+	// Load returns ErrNoToken when no token is saved.
+	Load(ctx context.Context) (*oauth2.Token, error)
+	Save(ctx context.Context, token *oauth2.Token) error
+	Delete(ctx context.Context) error
+}
+
 // Config holds configuration for OAuth2 authorization flow.
 type Config struct {
 	Oauth2Config *oauth2.Config
 	LoadToken    LoadTokenFunc
 	SaveToken    SaveTokenFunc
-	UserInfoURL  string
+	DeleteToken  DeleteTokenFunc
+	// Store is used when LoadToken, SaveToken or DeleteToken are not set.
+	Store       TokenStore
+	UserInfoURL string
+	// UserInfoTTL is how long Auth.FetchUserInfo caches the user info.
+	// Defaults to 5m, negative disables the cache.
+	UserInfoTTL time.Duration
+	// RevocationURL is the RFC 7009 endpoint Logout revokes the tokens at.
+	// Logout only forgets the tokens when it is empty.
+	RevocationURL string
+
+	// oidc is set by NewConfigFromIssuer to verify ID tokens.
+	oidc *oidcProvider
 }
 
 // AuthFlow holds everything needed to complete an in-progress OAuth2 PKCE flow.
@@ -30,6 +53,7 @@ type AuthFlow struct {
 	// state is kept private — Finalize validates it internally.
 	state    string
 	verifier string
+	nonce    string
 	cfg      *Config
 }
 
@@ -48,18 +72,28 @@ func (cfg *Config) PrepareAuthFlow(redirectURL string) (*AuthFlow, error) {
 		return nil, fmt.Errorf("%s: failed to generate PKCE params: %w", op, err)
 	}
 
-	cfg.Oauth2Config.RedirectURL = redirectURL
-	url := cfg.Oauth2Config.AuthCodeURL(
-		params.state,
+	opts := []oauth2.AuthCodeOption{
 		oauth2.AccessTypeOffline,
 		oauth2.SetAuthURLParam("code_challenge", params.challenge),
 		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
-	)
+	}
+
+	var nonce string
+	if cfg.oidc != nil {
+		if nonce, err = generateRandomString(); err != nil {
+			return nil, fmt.Errorf("%s: failed to generate nonce: %w", op, err)
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+
+	cfg.Oauth2Config.RedirectURL = redirectURL
+	url := cfg.Oauth2Config.AuthCodeURL(params.state, opts...)
 
 	return &AuthFlow{
 		URL:      url,
 		state:    params.state,
 		verifier: params.verifier,
+		nonce:    nonce,
 		cfg:      cfg,
 	}, nil
 }
@@ -82,22 +116,31 @@ func (f *AuthFlow) Finalize(ctx context.Context, state, code string) (*Auth, err
 		return nil, fmt.Errorf("%s: failed to exchange code: %w", op, err)
 	}
 
+	idToken, err := f.cfg.verifyTokenIDToken(ctx, token, f.nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to verify id token: %w", op, err)
+	}
+
 	if err := f.cfg.saveTokenIfNeeded(ctx, token); err != nil {
 		return nil, fmt.Errorf("%s: failed to save token: %w", op, err)
 	}
 
-	return &Auth{cfg: f.cfg, token: token}, nil
+	return &Auth{cfg: f.cfg, token: token, idToken: idToken}, nil
 }
 
 // loadToken loads a saved token and refreshes it if expired.
 func (cfg *Config) loadToken(ctx context.Context) (*oauth2.Token, error) {
 	const op = "lib.authorization.config.loadToken"
 
-	if cfg.LoadToken == nil {
+	load := cfg.LoadToken
+	if load == nil && cfg.Store != nil {
+		load = cfg.Store.Load
+	}
+	if load == nil {
 		return nil, fmt.Errorf("%s: LoadToken is not defined", op)
 	}
 
-	token, err := cfg.LoadToken(ctx)
+	token, err := load(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
@@ -119,26 +162,48 @@ func (cfg *Config) loadToken(ctx context.Context) (*oauth2.Token, error) {
 func (cfg *Config) refreshToken(ctx context.Context, t *oauth2.Token) (*oauth2.Token, error) {
 	const op = "lib.authorization.config.refreshToken"
 
-	token, err := cfg.Oauth2Config.TokenSource(ctx, t).Token()
+	// without the access token the source refreshes even a still valid
+	// token, which refresh-ahead relies on
+	stale := &oauth2.Token{RefreshToken: t.RefreshToken}
+
+	token, err := cfg.Oauth2Config.TokenSource(ctx, stale).Token()
 	if err != nil {
 		return nil, fmt.Errorf("%s: failed to refresh: %w", op, err)
 	}
 
-	if err := cfg.saveTokenIfNeeded(ctx, token); err != nil {
-		return nil, fmt.Errorf("%s: failed to save: %w", op, err)
-	}
-
 	return token, nil
 }
 
 func (cfg *Config) saveTokenIfNeeded(ctx context.Context, token *oauth2.Token) error {
 	const op = "lib.authorization.config.saveTokenIfNeeded"
 
-	if cfg.SaveToken == nil {
+	save := cfg.SaveToken
+	if save == nil && cfg.Store != nil {
+		save = cfg.Store.Save
+	}
+	if save == nil {
+		return nil
+	}
+
+	if err := save(ctx, token); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) deleteTokenIfNeeded(ctx context.Context) error {
+	const op = "lib.authorization.config.deleteTokenIfNeeded"
+
+	deleteToken := cfg.DeleteToken
+	if deleteToken == nil && cfg.Store != nil {
+		deleteToken = cfg.Store.Delete
+	}
+	if deleteToken == nil {
 		return nil
 	}
 
-	if err := cfg.SaveToken(ctx, token); err != nil {
+	if err := deleteToken(ctx); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -0,0 +1,89 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DeviceFlow holds an in-progress OAuth2 device authorization (RFC 8628).
+// Obtain it via PrepareDeviceFlow, show the user code and verification URI
+// to the user, then call Finalize to wait for the approval.
+type DeviceFlow struct {
+	// UserCode is the code the user enters at VerificationURI.
+	UserCode        string
+	VerificationURI string
+	// VerificationURIComplete already contains the user code, empty if the
+	// provider does not support it.
+	VerificationURIComplete string
+	Expiry                  time.Time
+
+	response *oauth2.DeviceAuthResponse
+	cfg      *Config
+}
+
+// PrepareDeviceFlow requests a device and user code from the provider.
+func (cfg *Config) PrepareDeviceFlow(ctx context.Context) (*DeviceFlow, error) {
+	const op = "lib.authorization.PrepareDeviceFlow"
+
+	if err := cfg.validateDevice(); err != nil {
+		return nil, fmt.Errorf("%s: invalid config: %w", op, err)
+	}
+
+	response, err := cfg.Oauth2Config.DeviceAuth(ctx, oauth2.AccessTypeOffline)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to request device code: %w", op, err)
+	}
+
+	return &DeviceFlow{
+		UserCode:                response.UserCode,
+		VerificationURI:         response.VerificationURI,
+		VerificationURIComplete: response.VerificationURIComplete,
+		Expiry:                  response.Expiry,
+		response:                response,
+		cfg:                     cfg,
+	}, nil
+}
+
+// Finalize polls the token endpoint at the interval requested by the
+// provider, slowing down when asked to, until the user approves or denies
+// the request or the code expires. The token is saved and a ready Auth
+// returned.
+func (f *DeviceFlow) Finalize(ctx context.Context) (*Auth, error) {
+	const op = "lib.authorization.DeviceFlow.Finalize"
+
+	token, err := f.cfg.Oauth2Config.DeviceAccessToken(ctx, f.response)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to get device token: %w", op, err)
+	}
+
+	idToken, err := f.cfg.verifyTokenIDToken(ctx, token, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to verify id token: %w", op, err)
+	}
+
+	if err := f.cfg.saveTokenIfNeeded(ctx, token); err != nil {
+		return nil, fmt.Errorf("%s: failed to save token: %w", op, err)
+	}
+
+	return &Auth{cfg: f.cfg, token: token, idToken: idToken}, nil
+}
+
+func (cfg *Config) validateDevice() error {
+	var errs []error
+
+	if cfg.Oauth2Config.ClientID == "" {
+		errs = append(errs, errors.New("missing client id"))
+	}
+	if cfg.Oauth2Config.Endpoint.DeviceAuthURL == "" {
+		errs = append(errs, errors.New("missing device auth url"))
+	}
+	if cfg.Oauth2Config.Endpoint.TokenURL == "" {
+		errs = append(errs, errors.New("missing token url"))
+	}
+
+	return errors.Join(errs...)
+}
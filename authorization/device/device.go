@@ -0,0 +1,96 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+)
+
+type Options struct {
+	// Output receives the instructions for the user. Defaults to os.Stdout.
+	Output io.Writer
+	// DisableQR skips printing the verification URL as a QR code.
+	DisableQR bool
+	// LightBackground draws the QR code for light terminals, see QRCodeDark.
+	LightBackground bool
+	// Prompt replaces the printed instructions, e.g. to show them in a UI.
+	Prompt func(*authorization.DeviceFlow) error
+}
+
+// Authorize performs the OAuth2 device authorization flow, for machines
+// without a browser: the user opens the verification URL on another device
+// and enters the printed code. opts may be nil.
+func Authorize(
+	ctx context.Context,
+	cfg *authorization.Config,
+	opts *Options,
+) (*authorization.Auth, error) {
+	const op = "authorization.device.Authorize"
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	flow, err := cfg.PrepareDeviceFlow(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to prepare device flow: %w", op, err)
+	}
+
+	prompt := opts.Prompt
+	if prompt == nil {
+		prompt = func(flow *authorization.DeviceFlow) error {
+			return printInstructions(opts, flow)
+		}
+	}
+	if err := prompt(flow); err != nil {
+		return nil, fmt.Errorf("%s: failed to prompt user: %w", op, err)
+	}
+
+	auth, err := flow.Finalize(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to finalize: %w", op, err)
+	}
+
+	return auth, nil
+}
+
+func printInstructions(opts *Options, flow *authorization.DeviceFlow) error {
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	if _, err := fmt.Fprintf(
+		output,
+		"To authorize, open this link:\n%s\nand enter the code: %s\n",
+		flow.VerificationURI,
+		flow.UserCode,
+	); err != nil {
+		return err
+	}
+
+	if opts.DisableQR {
+		return nil
+	}
+
+	url := flow.VerificationURIComplete
+	if url == "" {
+		url = flow.VerificationURI
+	}
+
+	render := QRCode
+	if opts.LightBackground {
+		render = QRCodeDark
+	}
+	qr, err := render(url)
+	if err != nil {
+		// the link is already printed, the code is a convenience
+		return nil
+	}
+
+	_, err = fmt.Fprintf(output, "\nOr scan this QR code:\n%s", qr)
+	return err
+}
@@ -0,0 +1,197 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+	"golang.org/x/oauth2"
+)
+
+// provider is a fake device authorization server approving every code on
+// the first poll.
+type provider struct {
+	*httptest.Server
+
+	verificationURI string
+	complete        string
+
+	mu         sync.Mutex
+	tokenPolls int
+}
+
+func newProvider(t *testing.T, verificationURI, complete string) *provider {
+	t.Helper()
+
+	p := &provider{verificationURI: verificationURI, complete: complete}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", p.handleDevice)
+	mux.HandleFunc("/token", p.handleToken)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *provider) handleDevice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"device_code":               "device-code",
+		"user_code":                 "WDJB-MJHT",
+		"verification_uri":          p.verificationURI,
+		"verification_uri_complete": p.complete,
+		"expires_in":                60,
+		"interval":                  1,
+	})
+}
+
+func (p *provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.tokenPolls++
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  "access",
+		"refresh_token": "refresh",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (p *provider) polls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.tokenPolls
+}
+
+func (p *provider) config() *authorization.Config {
+	return &authorization.Config{
+		Oauth2Config: &oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{
+				DeviceAuthURL: p.URL + "/device",
+				TokenURL:      p.URL + "/token",
+				AuthStyle:     oauth2.AuthStyleInParams,
+			},
+		},
+	}
+}
+
+func TestAuthorizeInstructions(t *testing.T) {
+	const (
+		uri      = "https://auth.example.com/activate"
+		complete = "https://auth.example.com/activate?user_code=WDJB-MJHT"
+	)
+
+	tests := []struct {
+		name      string
+		complete  string
+		disableQR bool
+		light     bool
+		qr        string
+	}{
+		{"qr of the complete uri", complete, false, false, complete},
+		{"qr of the uri without a complete one", "", false, false, uri},
+		{"qr for a light background", complete, false, true, complete},
+		{"qr disabled", complete, true, false, ""},
+		// the link is printed all the same
+		{"uri too long for a qr code", uri + "?" + strings.Repeat("x", 300), false, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newProvider(t, uri, tt.complete)
+			var output bytes.Buffer
+
+			auth, err := Authorize(t.Context(), p.config(), &Options{
+				Output:          &output,
+				DisableQR:       tt.disableQR,
+				LightBackground: tt.light,
+			})
+			if err != nil {
+				t.Fatalf("Authorize: %v", err)
+			}
+			if token, err := auth.Token(t.Context()); err != nil || token != "access" {
+				t.Errorf("Token = %q, %v, want access", token, err)
+			}
+
+			printed := output.String()
+			if !strings.Contains(printed, uri+"\n") || !strings.Contains(printed, "WDJB-MJHT") {
+				t.Errorf("instructions lack the link or the code:\n%s", printed)
+			}
+
+			want := ""
+			if tt.qr != "" {
+				render := QRCode
+				if tt.light {
+					render = QRCodeDark
+				}
+				qr, err := render(tt.qr)
+				if err != nil {
+					t.Fatalf("QRCode: %v", err)
+				}
+				want = "\nOr scan this QR code:\n" + qr
+			}
+			_, gotQR, _ := strings.Cut(printed, "WDJB-MJHT\n")
+			if gotQR != want {
+				t.Errorf("printed qr code:\n%s\nwant:\n%s", gotQR, want)
+			}
+		})
+	}
+}
+
+func TestAuthorizePrompt(t *testing.T) {
+	t.Run("replaces the instructions", func(t *testing.T) {
+		t.Parallel()
+
+		p := newProvider(t, "https://auth.example.com/activate", "")
+		var output bytes.Buffer
+		var prompted *authorization.DeviceFlow
+
+		_, err := Authorize(t.Context(), p.config(), &Options{
+			Output: &output,
+			Prompt: func(flow *authorization.DeviceFlow) error {
+				prompted = flow
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Authorize: %v", err)
+		}
+
+		if prompted == nil || prompted.UserCode != "WDJB-MJHT" || prompted.VerificationURI != p.verificationURI {
+			t.Errorf("prompted with %+v", prompted)
+		}
+		if output.Len() > 0 {
+			t.Errorf("instructions printed besides the prompt:\n%s", output.String())
+		}
+	})
+
+	t.Run("error aborts before polling", func(t *testing.T) {
+		t.Parallel()
+
+		p := newProvider(t, "https://auth.example.com/activate", "")
+		promptErr := errors.New("no display")
+
+		_, err := Authorize(t.Context(), p.config(), &Options{
+			Prompt: func(*authorization.DeviceFlow) error { return promptErr },
+		})
+		if !errors.Is(err, promptErr) {
+			t.Errorf("Authorize error = %v, want %v", err, promptErr)
+		}
+		if n := p.polls(); n != 0 {
+			t.Errorf("%d token polls after the prompt failed", n)
+		}
+	})
+}
@@ -0,0 +1,517 @@
+package device
+
+import (
+	"errors"
+	"strings"
+)
+
+// A minimal QR code encoder: byte mode, error correction level L,
+// versions 1 to 10, which fits URLs of up to 271 bytes.
+
+var ErrTooLong = errors.New("text too long for qr code")
+
+type qrVersion struct {
+	ecPerBlock int
+	// data codewords of each block
+	blocks []int
+	// alignment pattern centers
+	alignment []int
+}
+
+var qrVersions = []qrVersion{
+	1:  {7, []int{19}, nil},
+	2:  {10, []int{34}, []int{6, 18}},
+	3:  {15, []int{55}, []int{6, 22}},
+	4:  {20, []int{80}, []int{6, 26}},
+	5:  {26, []int{108}, []int{6, 30}},
+	6:  {18, []int{68, 68}, []int{6, 34}},
+	7:  {20, []int{78, 78}, []int{6, 22, 38}},
+	8:  {24, []int{97, 97}, []int{6, 24, 42}},
+	9:  {30, []int{116, 116}, []int{6, 26, 46}},
+	10: {18, []int{68, 68, 69, 69}, []int{6, 28, 50}},
+}
+
+// QRCode renders text as a QR code made of block characters, two modules
+// per line, with the four module quiet zone of ISO/IEC 18004. Light modules
+// are drawn, so it reads on dark terminals; use QRCodeDark on light ones.
+func QRCode(text string) (string, error) {
+	return renderQR(text, true)
+}
+
+// QRCodeDark is QRCode drawing the dark modules, for light terminals and
+// paper.
+func QRCodeDark(text string) (string, error) {
+	return renderQR(text, false)
+}
+
+func renderQR(text string, drawLight bool) (string, error) {
+	modules, err := encodeQR([]byte(text))
+	if err != nil {
+		return "", err
+	}
+
+	const quiet = 4
+	size := len(modules)
+	drawn := func(y, x int) bool {
+		y, x = y-quiet, x-quiet
+		if y < 0 || x < 0 || y >= size || x >= size {
+			return drawLight
+		}
+		return modules[y][x] != drawLight
+	}
+
+	var b strings.Builder
+	for y := 0; y < size+2*quiet; y += 2 {
+		for x := range size + 2*quiet {
+			top, bottom := drawn(y, x), drawn(y+1, x)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String(), nil
+}
+
+type qrMatrix struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func encodeQR(data []byte) ([][]bool, error) {
+	version := 0
+	for v := 1; v < len(qrVersions); v++ {
+		if qrCapacity(v) >= len(data) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := qrCodewords(data, version)
+
+	m := newQRMatrix(version)
+	m.drawFunctionPatterns(version)
+	m.drawCodewords(codewords)
+
+	best, bestPenalty := -1, 0
+	for mask := range 8 {
+		m.applyMask(mask)
+		m.drawFormatBits(mask)
+		if penalty := m.penalty(); best < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		m.applyMask(mask)
+	}
+	m.applyMask(best)
+	m.drawFormatBits(best)
+
+	return m.modules, nil
+}
+
+func qrCapacity(version int) int {
+	total := 0
+	for _, n := range qrVersions[version].blocks {
+		total += n
+	}
+
+	// mode and character count indicators
+	header := 4 + 8
+	if version >= 10 {
+		header = 4 + 16
+	}
+
+	return (total*8 - header) / 8
+}
+
+// qrCodewords encodes data in byte mode, splits it into blocks and
+// interleaves the blocks with their error correction codewords.
+func qrCodewords(data []byte, version int) []byte {
+	v := qrVersions[version]
+
+	capacity := 0
+	for _, n := range v.blocks {
+		capacity += n
+	}
+
+	var bits qrBits
+	bits.append(0b0100, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, capacity*8-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+
+	stream := bits.bytes()
+	for pad := 0; len(stream) < capacity; pad++ {
+		stream = append(stream, []byte{0xEC, 0x11}[pad%2])
+	}
+
+	generator := rsGenerator(v.ecPerBlock)
+	dataBlocks := make([][]byte, len(v.blocks))
+	ecBlocks := make([][]byte, len(v.blocks))
+	for i, n := range v.blocks {
+		dataBlocks[i] = stream[:n]
+		ecBlocks[i] = rsRemainder(stream[:n], generator)
+		stream = stream[n:]
+	}
+
+	var result []byte
+	for i := range v.blocks[len(v.blocks)-1] {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := range v.ecPerBlock {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+type qrBits []bool
+
+func (b *qrBits) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 == 1)
+	}
+}
+
+func (b qrBits) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	return result
+}
+
+func rsMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for range degree {
+		for j := range degree {
+			result[j] = rsMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = rsMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, g := range generator {
+			result[i] ^= rsMultiply(g, factor)
+		}
+	}
+
+	return result
+}
+
+func newQRMatrix(version int) *qrMatrix {
+	size := version*4 + 17
+
+	m := &qrMatrix{
+		size:     size,
+		modules:  make([][]bool, size),
+		function: make([][]bool, size),
+	}
+	for i := range size {
+		m.modules[i] = make([]bool, size)
+		m.function[i] = make([]bool, size)
+	}
+
+	return m
+}
+
+func (m *qrMatrix) set(x, y int, dark bool) {
+	m.modules[y][x] = dark
+	m.function[y][x] = true
+}
+
+func (m *qrMatrix) drawFunctionPatterns(version int) {
+	for i := range m.size {
+		m.set(6, i, i%2 == 0)
+		m.set(i, 6, i%2 == 0)
+	}
+
+	m.drawFinder(3, 3)
+	m.drawFinder(m.size-4, 3)
+	m.drawFinder(3, m.size-4)
+
+	alignment := qrVersions[version].alignment
+	last := len(alignment) - 1
+	for i, x := range alignment {
+		for j, y := range alignment {
+			// skip the ones overlapping the finders
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			m.drawAlignment(x, y)
+		}
+	}
+
+	// reserve the format areas, drawn for real once the mask is chosen
+	m.drawFormatBits(0)
+	m.drawVersion(version)
+}
+
+func (m *qrMatrix) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= m.size || y >= m.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			m.set(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (m *qrMatrix) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (m *qrMatrix) drawFormatBits(mask int) {
+	// error correction level L is 01
+	data := 1<<3 | mask
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return bits>>i&1 == 1
+	}
+
+	for i := range 6 {
+		m.set(8, i, bit(i))
+	}
+	m.set(8, 7, bit(6))
+	m.set(8, 8, bit(7))
+	m.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		m.set(14-i, 8, bit(i))
+	}
+
+	for i := range 8 {
+		m.set(m.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		m.set(8, m.size-15+i, bit(i))
+	}
+	m.set(8, m.size-8, true)
+}
+
+func (m *qrMatrix) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+
+	rem := version
+	for range 12 {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := version<<12 | rem
+
+	for i := range 18 {
+		dark := bits>>i&1 == 1
+		a, b := m.size-11+i%3, i/3
+		m.set(a, b, dark)
+		m.set(b, a, dark)
+	}
+}
+
+// drawCodewords fills the data area in the zigzag order.
+func (m *qrMatrix) drawCodewords(data []byte) {
+	i := 0
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range m.size {
+			for j := range 2 {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = m.size - 1 - vert
+				}
+				if m.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				m.modules[y][x] = data[i>>3]>>(7-i&7)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask xors the data modules with the mask pattern, so applying it
+// twice undoes it.
+func (m *qrMatrix) applyMask(mask int) {
+	for y := range m.size {
+		for x := range m.size {
+			if m.function[y][x] {
+				continue
+			}
+
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			m.modules[y][x] = m.modules[y][x] != invert
+		}
+	}
+}
+
+func (m *qrMatrix) penalty() int {
+	result := 0
+	dark := 0
+
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return m.modules[x][y]
+		}
+		return m.modules[y][x]
+	}
+
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	for _, vertical := range []bool{false, true} {
+		for y := range m.size {
+			run := 0
+			for x := range m.size {
+				if x > 0 && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					result += 3
+				} else if run > 5 {
+					result++
+				}
+
+				if x+7 <= m.size && m.matchesAt(at, x, y, vertical, finderLike) &&
+					(m.lightRun(at, x-4, y, vertical) || m.lightRun(at, x+7, y, vertical)) {
+					result += 40
+				}
+			}
+		}
+	}
+
+	for y := range m.size {
+		for x := range m.size {
+			if m.modules[y][x] {
+				dark++
+			}
+			if x+1 < m.size && y+1 < m.size {
+				c := m.modules[y][x]
+				if c == m.modules[y][x+1] && c == m.modules[y+1][x] && c == m.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	percent := dark * 100 / (m.size * m.size)
+	result += abs(percent-50) / 5 * 10
+
+	return result
+}
+
+func (m *qrMatrix) matchesAt(
+	at func(x, y int, vertical bool) bool,
+	x, y int,
+	vertical bool,
+	pattern []bool,
+) bool {
+	for i, dark := range pattern {
+		if at(x+i, y, vertical) != dark {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lightRun reports whether the four modules from x are light, the outside
+// of the symbol counting as light.
+func (m *qrMatrix) lightRun(at func(x, y int, vertical bool) bool, x, y int, vertical bool) bool {
+	for i := x; i < x+4; i++ {
+		if i >= 0 && i < m.size && at(i, y, vertical) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
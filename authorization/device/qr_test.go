@@ -0,0 +1,462 @@
+package device
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden qr codes in testdata")
+
+// qrSpec holds the ISO/IEC 18004 figures of a version at level L, written
+// down apart from the encoder tables so the decoder checks them.
+type qrSpec struct {
+	total     int
+	ec        int
+	blocks    []int
+	remainder int
+	alignment []int
+	// versionInfo is the BCH coded version for versions 7 and up
+	versionInfo int
+}
+
+var qrSpecs = map[int]qrSpec{
+	1:  {26, 7, []int{19}, 0, nil, 0},
+	2:  {44, 10, []int{34}, 7, []int{6, 18}, 0},
+	6:  {172, 18, []int{68, 68}, 7, []int{6, 34}, 0},
+	7:  {196, 20, []int{78, 78}, 0, []int{6, 22, 38}, 0x07C94},
+	10: {346, 18, []int{68, 68, 69, 69}, 0, []int{6, 28, 50}, 0x0A4D3},
+}
+
+// qrFormatL is the format information of level L by mask.
+var qrFormatL = [8]int{0x77C4, 0x72F3, 0x7DAA, 0x789D, 0x662F, 0x6318, 0x6C41, 0x6976}
+
+// qrText returns a URL like text of n bytes.
+func qrText(n int) string {
+	const url = "https://auth.example.com/activate?user_code=WDJB-MJHT&"
+	return strings.Repeat(url, n/len(url)+1)[:n]
+}
+
+func TestEncodeQRGolden(t *testing.T) {
+	tests := []struct {
+		version int
+		size    int
+	}{
+		{1, 17},   // largest version 1
+		{2, 18},   // smallest version 2
+		{6, 120},  // two blocks
+		{7, 150},  // version information
+		{10, 271}, // four blocks of two sizes, 16 bit length
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("v%d", tt.version)
+		t.Run(name, func(t *testing.T) {
+			text := qrText(tt.size)
+			modules, err := encodeQR([]byte(text))
+			if err != nil {
+				t.Fatalf("encodeQR: %v", err)
+			}
+
+			if got, want := len(modules), tt.version*4+17; got != want {
+				t.Fatalf("size = %d, want %d of version %d", got, want, tt.version)
+			}
+			if decoded := decodeQR(t, modules, tt.version); decoded != text {
+				t.Errorf("decoded %q, want %q", decoded, text)
+			}
+
+			path := filepath.Join("testdata", "qr-"+name+".txt")
+			golden := formatQR(modules)
+			if *update {
+				if err := os.WriteFile(path, []byte(golden), 0o644); err != nil {
+					t.Fatalf("write golden: %v", err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden: %v", err)
+			}
+			if golden != string(want) {
+				t.Errorf("matrix differs from %s:\n%s", path, golden)
+			}
+		})
+	}
+}
+
+func TestEncodeQRTooLong(t *testing.T) {
+	if _, err := encodeQR([]byte(qrText(271))); err != nil {
+		t.Errorf("encodeQR of 271 bytes: %v", err)
+	}
+	if _, err := encodeQR([]byte(qrText(272))); !errors.Is(err, ErrTooLong) {
+		t.Errorf("encodeQR of 272 bytes error = %v, want %v", err, ErrTooLong)
+	}
+	if _, err := QRCode(qrText(300)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("QRCode error = %v, want %v", err, ErrTooLong)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	text := qrText(17)
+	modules, err := encodeQR([]byte(text))
+	if err != nil {
+		t.Fatalf("encodeQR: %v", err)
+	}
+
+	// four modules of quiet zone around, two rows per line
+	width := len(modules) + 8
+	render := func(t *testing.T, qrCode func(string) (string, error)) []string {
+		t.Helper()
+
+		qr, err := qrCode(text)
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(qr, "\n"), "\n")
+		if want := (width + 1) / 2; len(lines) != want {
+			t.Errorf("%d lines, want %d", len(lines), want)
+		}
+		for i, line := range lines {
+			if n := utf8.RuneCountInString(line); n != width {
+				t.Errorf("line %d is %d wide, want %d", i, n, width)
+			}
+		}
+		return lines
+	}
+
+	// the quiet zone takes the first two lines; on the third the finder
+	// corner starts at column four, its light ring below its dark top half
+	tests := []struct {
+		name   string
+		qrCode func(string) (string, error)
+		quiet  string
+		corner string
+	}{
+		{"light modules", QRCode, "█", " ▄"},
+		{"dark modules", QRCodeDark, " ", "█▀"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := render(t, tt.qrCode)
+			for _, line := range lines[:2] {
+				if line != strings.Repeat(tt.quiet, width) {
+					t.Errorf("quiet zone line = %q", line)
+				}
+			}
+			if got := string([]rune(lines[2])[3:6]); got != tt.quiet+tt.corner {
+				t.Errorf("top left finder corner = %q, want %q", got, tt.quiet+tt.corner)
+			}
+		})
+	}
+}
+
+func formatQR(modules [][]bool) string {
+	var b strings.Builder
+	for _, row := range modules {
+		for _, dark := range row {
+			if dark {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// decodeQR reads modules back following the standard, checking the
+// function patterns, format and version information and error correction
+// on the way.
+func decodeQR(t *testing.T, modules [][]bool, version int) string {
+	t.Helper()
+
+	spec := qrSpecs[version]
+	size := len(modules)
+	at := func(x, y int) bool { return modules[y][x] }
+
+	// finders with their separators
+	for _, corner := range [][2]int{{0, 0}, {size - 7, 0}, {0, size - 7}} {
+		for dy := -1; dy <= 7; dy++ {
+			for dx := -1; dx <= 7; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || y < 0 || x >= size || y >= size {
+					continue
+				}
+				ring := max(abs(dx-3), abs(dy-3))
+				if want := ring != 2 && ring != 4; at(x, y) != want {
+					t.Fatalf("finder at %v: module (%d, %d) = %v", corner, x, y, at(x, y))
+				}
+			}
+		}
+	}
+
+	for i := 8; i < size-8; i++ {
+		if at(i, 6) != (i%2 == 0) || at(6, i) != (i%2 == 0) {
+			t.Fatalf("timing pattern broken at %d", i)
+		}
+	}
+
+	isAlignment := func(x, y int) (int, int, bool) {
+		last := len(spec.alignment) - 1
+		for i, cx := range spec.alignment {
+			for j, cy := range spec.alignment {
+				if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+					continue
+				}
+				if abs(x-cx) <= 2 && abs(y-cy) <= 2 {
+					return cx, cy, true
+				}
+			}
+		}
+		return 0, 0, false
+	}
+	for y := range size {
+		for x := range size {
+			cx, cy, ok := isAlignment(x, y)
+			if !ok {
+				continue
+			}
+			if want := max(abs(x-cx), abs(y-cy)) != 1; at(x, y) != want {
+				t.Fatalf("alignment at (%d, %d): module (%d, %d) = %v", cx, cy, x, y, at(x, y))
+			}
+		}
+	}
+
+	if !at(8, size-8) {
+		t.Fatal("dark module is light")
+	}
+
+	// both format copies, least significant bit first
+	var format, formatCopy int
+	formatBit := func(value *int, i int, dark bool) {
+		if dark {
+			*value |= 1 << i
+		}
+	}
+	for i := range 6 {
+		formatBit(&format, i, at(8, i))
+	}
+	formatBit(&format, 6, at(8, 7))
+	formatBit(&format, 7, at(8, 8))
+	formatBit(&format, 8, at(7, 8))
+	for i := 9; i < 15; i++ {
+		formatBit(&format, i, at(14-i, 8))
+	}
+	for i := range 8 {
+		formatBit(&formatCopy, i, at(size-1-i, 8))
+	}
+	for i := 8; i < 15; i++ {
+		formatBit(&formatCopy, i, at(8, size-15+i))
+	}
+	if format != formatCopy {
+		t.Fatalf("format copies differ: %015b and %015b", format, formatCopy)
+	}
+	mask := -1
+	for m, bits := range qrFormatL {
+		if bits == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format %015b is no level L format", format)
+	}
+
+	if version >= 7 {
+		var info, infoCopy int
+		for i := range 18 {
+			a, b := size-11+i%3, i/3
+			formatBit(&info, i, at(a, b))
+			formatBit(&infoCopy, i, at(b, a))
+		}
+		if info != spec.versionInfo || infoCopy != spec.versionInfo {
+			t.Fatalf("version information %018b and %018b, want %018b", info, infoCopy, spec.versionInfo)
+		}
+	}
+
+	isFunction := func(x, y int) bool {
+		switch {
+		case x < 9 && y < 9, x >= size-8 && y < 9, x < 9 && y >= size-8:
+			return true
+		case x == 6 || y == 6:
+			return true
+		case version >= 7 && (x >= size-11 && x < size-8 && y < 6 || y >= size-11 && y < size-8 && x < 6):
+			return true
+		}
+		_, _, ok := isAlignment(x, y)
+		return ok
+	}
+
+	masked := func(x, y int) bool {
+		i, j := y, x
+		switch mask {
+		case 0:
+			return (i+j)%2 == 0
+		case 1:
+			return i%2 == 0
+		case 2:
+			return j%3 == 0
+		case 3:
+			return (i+j)%3 == 0
+		case 4:
+			return (i/2+j/3)%2 == 0
+		case 5:
+			return i*j%2+i*j%3 == 0
+		case 6:
+			return (i*j%2+i*j%3)%2 == 0
+		default:
+			return ((i+j)%2+i*j%3)%2 == 0
+		}
+	}
+
+	// two columns at a time from the right, alternating up and down
+	var bits []bool
+	up := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := range size {
+			y := i
+			if up {
+				y = size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if !isFunction(x, y) {
+					bits = append(bits, at(x, y) != masked(x, y))
+				}
+			}
+		}
+		up = !up
+	}
+	if want := spec.total*8 + spec.remainder; len(bits) != want {
+		t.Fatalf("%d data modules, want %d", len(bits), want)
+	}
+	for i, bit := range bits[spec.total*8:] {
+		if bit {
+			t.Errorf("remainder bit %d is set", i)
+		}
+	}
+
+	codewords := make([]byte, spec.total)
+	for i := range codewords {
+		for _, bit := range bits[i*8 : i*8+8] {
+			codewords[i] <<= 1
+			if bit {
+				codewords[i] |= 1
+			}
+		}
+	}
+
+	// undo the interleaving, then every block must be a code word
+	blocks := make([][]byte, len(spec.blocks))
+	next := 0
+	for i := range spec.blocks[len(spec.blocks)-1] {
+		for b, n := range spec.blocks {
+			if i < n {
+				blocks[b] = append(blocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+	for range spec.ec {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[next])
+			next++
+		}
+	}
+
+	var data []byte
+	for b, block := range blocks {
+		for i := range spec.ec {
+			if s := syndrome(block, i); s != 0 {
+				t.Fatalf("block %d: syndrome %d = %#x", b, i, s)
+			}
+		}
+		data = append(data, block[:spec.blocks[b]]...)
+	}
+
+	// byte mode segment, terminator and padding
+	stream := make([]bool, 0, len(data)*8)
+	for _, c := range data {
+		for i := 7; i >= 0; i-- {
+			stream = append(stream, c>>i&1 == 1)
+		}
+	}
+	read := func(n int) int {
+		v := 0
+		for _, bit := range stream[:n] {
+			v <<= 1
+			if bit {
+				v |= 1
+			}
+		}
+		stream = stream[n:]
+		return v
+	}
+
+	if m := read(4); m != 0b0100 {
+		t.Fatalf("mode = %04b, want byte mode", m)
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	text := make([]byte, read(countBits))
+	for i := range text {
+		text[i] = byte(read(8))
+	}
+
+	if n := min(4, len(stream)); read(n) != 0 {
+		t.Error("terminator is not zero")
+	}
+	if n := len(stream) % 8; read(n) != 0 {
+		t.Error("bit padding is not zero")
+	}
+	for i := 0; len(stream) > 0; i++ {
+		if pad := read(8); pad != []int{0xEC, 0x11}[i%2] {
+			t.Errorf("pad codeword %d = %#x", i, pad)
+		}
+	}
+
+	return string(text)
+}
+
+// syndrome evaluates block at the generator root 2^i in GF(256).
+func syndrome(block []byte, i int) byte {
+	root := byte(1)
+	for range i {
+		root = gfMultiply(root, 2)
+	}
+
+	var s byte
+	for _, c := range block {
+		s = gfMultiply(s, root) ^ c
+	}
+
+	return s
+}
+
+// gfMultiply multiplies by shifting and adding, modulo x^8+x^4+x^3+x^2+1.
+func gfMultiply(x, y byte) byte {
+	var product byte
+	for y > 0 {
+		if y&1 == 1 {
+			product ^= x
+		}
+		carry := x&0x80 != 0
+		x <<= 1
+		if carry {
+			x ^= 0x1D
+		}
+		y >>= 1
+	}
+
+	return product
+}
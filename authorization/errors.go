@@ -5,6 +5,9 @@ import "errors"
 var (
 	// ErrNoToken indicates that no token is available for authentication.
 	ErrNoToken = errors.New("no token")
-	// ErrTokenLocked indicates that the token is currently locked by another goroutine.
+	// ErrTokenLocked indicates that the token is being refreshed.
 	ErrTokenLocked = errors.New("token is locked")
+	// ErrReauthRequired indicates that the provider rejected the refresh for
+	// good, e.g. the grant was revoked, and the user has to log in again.
+	ErrReauthRequired = errors.New("reauthorization required")
 )
@@ -0,0 +1,322 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeySetTTL             = time.Hour
+	defaultKeySetRefreshInterval = time.Minute
+	defaultKeySetFetchTimeout    = 30 * time.Second
+)
+
+type KeySetOptions struct {
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// TTL is how long fetched keys are used before being fetched again.
+	// Defaults to 1h.
+	TTL time.Duration
+	// MinRefreshInterval limits refetching on unknown key ids and after
+	// failed fetches, so tokens with made up ids can not flood the provider.
+	// Defaults to 1m.
+	MinRefreshInterval time.Duration
+}
+
+// KeySet is a JWKS endpoint with the keys cached. Keys are fetched again
+// when the cache expires or a token is signed with an unknown key, which
+// picks up rotated keys. The cached keys stay in use while fetching fails.
+type KeySet struct {
+	url  string
+	opts KeySetOptions
+
+	mu          sync.Mutex
+	keys        []*jsonWebKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetchErr    error
+	// inflight is the fetch in progress, shared by everyone waiting for it.
+	inflight *fetchCall
+}
+
+type fetchCall struct {
+	done chan struct{}
+	err  error
+}
+
+type jsonWebKey struct {
+	ID        string
+	Algorithm string
+	publicKey crypto.PublicKey
+}
+
+// NewKeySet returns a key set fetched from url on first use. opts may be nil.
+func NewKeySet(url string, opts *KeySetOptions) *KeySet {
+	s := &KeySet{url: url}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.HTTPClient == nil {
+		s.opts.HTTPClient = http.DefaultClient
+	}
+	if s.opts.TTL <= 0 {
+		s.opts.TTL = defaultKeySetTTL
+	}
+	if s.opts.MinRefreshInterval <= 0 {
+		s.opts.MinRefreshInterval = defaultKeySetRefreshInterval
+	}
+
+	return s
+}
+
+// keysFor returns the candidate keys for a token header. No lock is held
+// while the keys are fetched.
+func (s *KeySet) keysFor(ctx context.Context, kid, alg string) ([]crypto.PublicKey, error) {
+	const op = "jwt.jwks.keysFor"
+
+	s.mu.Lock()
+	stale := time.Since(s.fetchedAt) > s.opts.TTL
+	s.mu.Unlock()
+
+	if stale {
+		// expired keys are better than none while the provider is down
+		if err := s.refresh(ctx); err != nil && !s.cached() {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	keys := s.match(kid, alg)
+	if len(keys) == 0 && s.refresh(ctx) == nil {
+		keys = s.match(kid, alg)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: kid %q: %w", op, kid, ErrUnknownKey)
+	}
+
+	return keys, nil
+}
+
+func (s *KeySet) cached() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.fetchedAt.IsZero()
+}
+
+func (s *KeySet) match(kid, alg string) []crypto.PublicKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []crypto.PublicKey
+	for _, key := range s.keys {
+		if kid != "" && key.ID != kid {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != alg {
+			continue
+		}
+		result = append(result, key.publicKey)
+	}
+
+	return result
+}
+
+// refresh fetches the keys. Within MinRefreshInterval of the last attempt
+// it only fetches expired keys the last attempt did fetch, otherwise the
+// error of that attempt is returned. Concurrent callers share one fetch,
+// which is not canceled with ctx.
+func (s *KeySet) refresh(ctx context.Context) error {
+	s.mu.Lock()
+	call := s.inflight
+	if call == nil {
+		expired := s.fetchErr == nil && time.Since(s.fetchedAt) > s.opts.TTL
+		if !expired && time.Since(s.attemptedAt) < s.opts.MinRefreshInterval {
+			err := s.fetchErr
+			s.mu.Unlock()
+			return err
+		}
+
+		call = &fetchCall{done: make(chan struct{})}
+		s.inflight = call
+		s.attemptedAt = time.Now()
+		go s.runFetch(context.WithoutCancel(ctx), call)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.done:
+		return call.err
+	}
+}
+
+func (s *KeySet) runFetch(ctx context.Context, call *fetchCall) {
+	ctx, cancel := context.WithTimeout(ctx, defaultKeySetFetchTimeout)
+	defer cancel()
+
+	keys, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	s.inflight = nil
+	s.fetchErr = err
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = time.Now()
+	}
+	s.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+}
+
+func (s *KeySet) fetch(ctx context.Context) ([]*jsonWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keys request failed, status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal keys: %w", err)
+	}
+
+	keys := make([]*jsonWebKey, 0, len(set.Keys))
+	for _, raw := range set.Keys {
+		// keys of unsupported types are skipped, the provider may publish
+		// more than we need
+		if key, err := parseJSONWebKey(raw); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func parseJSONWebKey(raw json.RawMessage) (*jsonWebKey, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, errors.New("not a signing key")
+	}
+
+	key := &jsonWebKey{ID: jwk.Kid, Algorithm: jwk.Alg}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		key.publicKey = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid ec point size")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		if key.publicKey, err = ecdsa.ParseUncompressedPublicKey(curve, point); err != nil {
+			return nil, err
+		}
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		key.publicKey = ed25519.PublicKey(x)
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	return key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty integer")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
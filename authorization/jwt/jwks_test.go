@@ -0,0 +1,118 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt"
+)
+
+func verify(t *testing.T, keys *jwt.KeySet, token string) error {
+	t.Helper()
+
+	var claims json.RawMessage
+	_, err := jwt.Verify(t.Context(), token, keys, &claims)
+	return err
+}
+
+func TestKeySetUnknownKeyRefetch(t *testing.T) {
+	k1, k2, k3 := newKey(t, "k1", "RS256"), newKey(t, "k2", "RS256"), newKey(t, "k3", "RS256")
+	issuer := newIssuer(t, k1)
+	keys := jwt.NewKeySet(issuer.JWKSURL(), &jwt.KeySetOptions{MinRefreshInterval: 50 * time.Millisecond})
+
+	if err := verify(t, keys, sign(t, k1, validClaims())); err != nil {
+		t.Fatalf("Verify k1: %v", err)
+	}
+	if n := issuer.JWKSRequests(); n != 1 {
+		t.Fatalf("%d jwks requests, want 1", n)
+	}
+
+	// the rotated key is picked up with a single refetch
+	issuer.SetKeys(k1, k2)
+	time.Sleep(60 * time.Millisecond)
+	if err := verify(t, keys, sign(t, k2, validClaims())); err != nil {
+		t.Fatalf("Verify k2: %v", err)
+	}
+	if n := issuer.JWKSRequests(); n != 2 {
+		t.Errorf("%d jwks requests, want 2", n)
+	}
+
+	// unknown ids right after a fetch do not reach the provider
+	for range 5 {
+		if err := verify(t, keys, sign(t, k3, validClaims())); !errors.Is(err, jwt.ErrUnknownKey) {
+			t.Errorf("Verify k3 error = %v, want %v", err, jwt.ErrUnknownKey)
+		}
+	}
+	if n := issuer.JWKSRequests(); n != 2 {
+		t.Errorf("%d jwks requests after unknown keys, want 2", n)
+	}
+}
+
+func TestKeySetServesCachedKeysWhenFetchFails(t *testing.T) {
+	key := newKey(t, "k1", "ES256")
+	issuer := newIssuer(t, key)
+	keys := jwt.NewKeySet(issuer.JWKSURL(), &jwt.KeySetOptions{
+		TTL:                10 * time.Millisecond,
+		MinRefreshInterval: time.Hour,
+	})
+
+	token := sign(t, key, validClaims())
+	if err := verify(t, keys, token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	issuer.SetFailing(true)
+	time.Sleep(20 * time.Millisecond)
+
+	for range 5 {
+		if err := verify(t, keys, token); err != nil {
+			t.Errorf("Verify with expired cache and failing provider: %v", err)
+		}
+	}
+	// the failed refresh is backed off
+	if n := issuer.JWKSRequests(); n != 2 {
+		t.Errorf("%d jwks requests, want 2", n)
+	}
+}
+
+func TestKeySetFirstFetchFails(t *testing.T) {
+	key := newKey(t, "k1", "RS256")
+	issuer := newIssuer(t, key)
+	issuer.SetFailing(true)
+	keys := jwt.NewKeySet(issuer.JWKSURL(), nil)
+
+	token := sign(t, key, validClaims())
+	for range 3 {
+		if err := verify(t, keys, token); err == nil {
+			t.Error("Verify succeeded without keys")
+		}
+	}
+	if n := issuer.JWKSRequests(); n != 1 {
+		t.Errorf("%d jwks requests, want 1", n)
+	}
+}
+
+func TestKeySetConcurrentFetch(t *testing.T) {
+	key := newKey(t, "k1", "EdDSA")
+	issuer := newIssuer(t, key)
+	keys := jwt.NewKeySet(issuer.JWKSURL(), nil)
+
+	token := sign(t, key, validClaims())
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if err := verify(t, keys, token); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := issuer.JWKSRequests(); n != 1 {
+		t.Errorf("%d jwks requests, want 1", n)
+	}
+}
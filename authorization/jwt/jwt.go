@@ -0,0 +1,249 @@
+// Package jwt verifies JSON Web Tokens signed with asymmetric keys published
+// as a JWKS, as used for OpenID Connect ID tokens and JWT access tokens.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed            = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrUnknownKey           = errors.New("unknown key")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrExpired              = errors.New("token expired")
+	ErrNotYetValid          = errors.New("token not yet valid")
+	ErrIssuedInFuture       = errors.New("token issued in the future")
+	ErrInvalidIssuer        = errors.New("invalid issuer")
+	ErrInvalidAudience      = errors.New("invalid audience")
+)
+
+var algorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+// Claims are the registered claims of RFC 7519.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	ID        string   `json:"jti"`
+}
+
+// Audience is a single string or an array of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	return slices.Contains(a, audience)
+}
+
+// Expected describes the values the registered claims are checked against.
+type Expected struct {
+	// Issuer and Audience are not checked when empty.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew in the time checks.
+	Leeway time.Duration
+	// Time defaults to now.
+	Time time.Time
+}
+
+// Validate checks the issuer, audience, validity period and issue time.
+// Tokens without an expiry are rejected.
+func (c *Claims) Validate(expected Expected) error {
+	now := expected.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if expected.Issuer != "" && c.Issuer != expected.Issuer {
+		return fmt.Errorf("%q: %w", c.Issuer, ErrInvalidIssuer)
+	}
+	if expected.Audience != "" && !c.Audience.Contains(expected.Audience) {
+		return fmt.Errorf("%q: %w", c.Audience, ErrInvalidAudience)
+	}
+	if c.Expiry == 0 || now.Add(-expected.Leeway).After(time.Unix(c.Expiry, 0)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(expected.Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	if c.IssuedAt != 0 && now.Add(expected.Leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrIssuedInFuture
+	}
+
+	return nil
+}
+
+// Verify checks the signature of raw against keys and unmarshals its
+// payload into claims. The registered claims are not validated, embed
+// Claims in claims and call Validate for that.
+func Verify(ctx context.Context, raw string, keys *KeySet, claims any) (*Header, error) {
+	const op = "jwt.Verify"
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%s: %w", op, ErrMalformed)
+	}
+
+	header := new(Header)
+	if err := decodeSegment(parts[0], header); err != nil {
+		return nil, fmt.Errorf("%s: failed to decode header: %w", op, err)
+	}
+
+	// "none" and the symmetric algorithms are never accepted
+	if !slices.Contains(algorithms, header.Algorithm) {
+		return nil, fmt.Errorf("%s: %q: %w", op, header.Algorithm, ErrUnsupportedAlgorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to decode signature: %w", op, ErrMalformed)
+	}
+
+	candidates, err := keys.keysFor(ctx, header.KeyID, header.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := slices.ContainsFunc(candidates, func(key crypto.PublicKey) bool {
+		return verifySignature(header.Algorithm, key, signed, signature) == nil
+	})
+	if !verified {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidSignature)
+	}
+
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("%s: failed to decode payload: %w", op, err)
+	}
+
+	return header, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(edKey, signed, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	digest := sum(hash, signed)
+
+	switch alg[:2] {
+	case "RS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature) != nil {
+			return ErrInvalidSignature
+		}
+	case "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPSS(rsaKey, hash, digest, signature, nil) != nil {
+			return ErrInvalidSignature
+		}
+	case "ES":
+		// the curve is fixed by the algorithm, e.g. P-256 for ES256
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != curves[alg] {
+			return ErrInvalidSignature
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return ErrInvalidSignature
+		}
+	}
+
+	return nil
+}
+
+func sum(hash crypto.Hash, data []byte) []byte {
+	switch hash {
+	case crypto.SHA384:
+		digest := sha512.Sum384(data)
+		return digest[:]
+	case crypto.SHA512:
+		digest := sha512.Sum512(data)
+		return digest[:]
+	default:
+		digest := sha256.Sum256(data)
+		return digest[:]
+	}
+}
@@ -0,0 +1,208 @@
+package jwt_test
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt"
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt/jwttest"
+)
+
+func newKey(t *testing.T, id, alg string) *jwttest.Key {
+	t.Helper()
+
+	key, err := jwttest.NewKey(id, alg)
+	if err != nil {
+		t.Fatalf("NewKey(%q): %v", alg, err)
+	}
+
+	return key
+}
+
+func newIssuer(t *testing.T, keys ...*jwttest.Key) *jwttest.Issuer {
+	t.Helper()
+
+	issuer := jwttest.NewIssuer(keys...)
+	t.Cleanup(issuer.Close)
+
+	return issuer
+}
+
+func validClaims() jwt.Claims {
+	return jwt.Claims{
+		Issuer:   "issuer",
+		Subject:  "u1",
+		Audience: jwt.Audience{"client"},
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+		IssuedAt: time.Now().Unix(),
+	}
+}
+
+func sign(t *testing.T, key *jwttest.Key, claims any) string {
+	t.Helper()
+
+	token, err := key.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	for _, alg := range []string{"RS256", "PS256", "ES256", "ES384", "ES512", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			key := newKey(t, "k1", alg)
+			keys := jwt.NewKeySet(newIssuer(t, key).JWKSURL(), nil)
+
+			var claims jwt.Claims
+			header, err := jwt.Verify(t.Context(), sign(t, key, validClaims()), keys, &claims)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if header.Algorithm != alg || header.KeyID != "k1" {
+				t.Errorf("header = %+v, want alg %q kid %q", header, alg, "k1")
+			}
+			if claims.Subject != "u1" {
+				t.Errorf("subject = %q, want %q", claims.Subject, "u1")
+			}
+		})
+	}
+}
+
+func TestVerifyRejected(t *testing.T) {
+	rsaKey := newKey(t, "rsa", "RS256")
+	// published without alg, so only the curve ties it to ES384
+	ecKey := newKey(t, "ec", "ES384")
+	ecKey.Algorithm = ""
+	keys := jwt.NewKeySet(newIssuer(t, rsaKey, ecKey).JWKSURL(), nil)
+
+	valid := sign(t, rsaKey, validClaims())
+	parts := strings.Split(valid, ".")
+
+	otherKey := newKey(t, "rsa", "RS256")
+
+	none, err := jwttest.Encode(
+		map[string]string{"alg": "none", "kid": "rsa"},
+		validClaims(),
+		func([]byte) ([]byte, error) { return nil, nil },
+	)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// HS256 keyed with the public RSA key, which verifiers mixing up key
+	// types would accept
+	publicDER, err := x509.MarshalPKIXPublicKey(rsaKey.Public().(*rsa.PublicKey))
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	hs, err := jwttest.Encode(
+		map[string]string{"alg": "HS256", "kid": "rsa"},
+		validClaims(),
+		func(input []byte) ([]byte, error) {
+			mac := hmac.New(sha256.New, publicDER)
+			mac.Write(input)
+			return mac.Sum(nil), nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// an ES256 header on a token signed with the P-384 key
+	wrongCurve, err := ecKey.SignAs("ES256", validClaims())
+	if err != nil {
+		t.Fatalf("SignAs: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"not three parts", "a.b", jwt.ErrMalformed},
+		{"bad header", "!." + parts[1] + "." + parts[2], jwt.ErrMalformed},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!", jwt.ErrMalformed},
+		{"bad signature", parts[0] + "." + parts[1] + "." + strings.Split(sign(t, otherKey, validClaims()), ".")[2], jwt.ErrInvalidSignature},
+		{"tampered payload", parts[0] + "." + strings.Split(sign(t, rsaKey, jwt.Claims{Subject: "admin"}), ".")[1] + "." + parts[2], jwt.ErrInvalidSignature},
+		{"alg none", none, jwt.ErrUnsupportedAlgorithm},
+		{"alg HS256 with rsa key", hs, jwt.ErrUnsupportedAlgorithm},
+		{"ec curve mismatch", wrongCurve, jwt.ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims json.RawMessage
+			if _, err := jwt.Verify(t.Context(), tt.token, keys, &claims); !errors.Is(err, tt.want) {
+				t.Errorf("Verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	leeway := time.Minute
+
+	tests := []struct {
+		name   string
+		claims jwt.Claims
+		want   error
+	}{
+		{"valid", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() + 60}, nil},
+		{"audience among many", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"other", "aud"}, Expiry: now.Unix() + 60}, nil},
+		{"issuer mismatch", jwt.Claims{Issuer: "other", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() + 60}, jwt.ErrInvalidIssuer},
+		{"audience mismatch", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"other"}, Expiry: now.Unix() + 60}, jwt.ErrInvalidAudience},
+		{"no expiry", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}}, jwt.ErrExpired},
+		{"expired within leeway", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() - 30}, nil},
+		{"expired beyond leeway", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() - 90}, jwt.ErrExpired},
+		{"nbf within leeway", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() + 600, NotBefore: now.Unix() + 30}, nil},
+		{"nbf beyond leeway", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() + 600, NotBefore: now.Unix() + 90}, jwt.ErrNotYetValid},
+		{"iat within leeway", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() + 600, IssuedAt: now.Unix() + 30}, nil},
+		{"iat beyond leeway", jwt.Claims{Issuer: "iss", Audience: jwt.Audience{"aud"}, Expiry: now.Unix() + 600, IssuedAt: now.Unix() + 90}, jwt.ErrIssuedInFuture},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.claims.Validate(jwt.Expected{
+				Issuer:   "iss",
+				Audience: "aud",
+				Leeway:   leeway,
+				Time:     now,
+			})
+			if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+				t.Errorf("Validate error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudienceUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want jwt.Audience
+	}{
+		{`"a"`, jwt.Audience{"a"}},
+		{`["a","b"]`, jwt.Audience{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var got jwt.Audience
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Audience = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
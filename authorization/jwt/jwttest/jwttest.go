@@ -0,0 +1,281 @@
+// Package jwttest provides signing keys and a fake issuer publishing them
+// as a JWKS, for tests of code verifying tokens with the jwt package, in
+// the spirit of net/http/httptest.
+package jwttest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Key is a signing key, its Algorithm is one of RS256, PS256, ES256, ES384,
+// ES512 and EdDSA. The JWKS publishes the key with Algorithm, clear it to
+// publish the key for any algorithm.
+type Key struct {
+	ID        string
+	Algorithm string
+	signer    crypto.Signer
+}
+
+// NewKey generates a key for alg.
+func NewKey(id, alg string) (*Key, error) {
+	const op = "jwttest.NewKey"
+
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch alg {
+	case "RS256", "PS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		signer, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%s: unsupported algorithm %q", op, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to generate key: %w", op, err)
+	}
+
+	return &Key{ID: id, Algorithm: alg, signer: signer}, nil
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.signer.Public()
+}
+
+// Sign returns a token with claims signed by k.
+func (k *Key) Sign(claims any) (string, error) {
+	return k.SignAs(k.Algorithm, claims)
+}
+
+// SignAs signs claims with k but puts alg into the header, e.g. to sign an
+// ES256 token with a P-384 key.
+func (k *Key) SignAs(alg string, claims any) (string, error) {
+	return k.encode(map[string]string{"alg": alg, "kid": k.ID, "typ": "JWT"}, claims)
+}
+
+// SignWithType signs claims with k and typ in the header, e.g. "at+jwt" of
+// RFC 9068 access tokens. An empty typ is left out.
+func (k *Key) SignWithType(typ string, claims any) (string, error) {
+	header := map[string]string{"alg": k.Algorithm, "kid": k.ID}
+	if typ != "" {
+		header["typ"] = typ
+	}
+
+	return k.encode(header, claims)
+}
+
+func (k *Key) encode(header map[string]string, claims any) (string, error) {
+	return Encode(header, claims, func(input []byte) ([]byte, error) {
+		return k.sign(header["alg"], input)
+	})
+}
+
+func (k *Key) sign(alg string, input []byte) ([]byte, error) {
+	switch key := k.signer.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, input), nil
+
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(input)
+		if alg == "PS256" {
+			return rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], nil)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+
+	case *ecdsa.PrivateKey:
+		// hashed as alg says, whatever the curve
+		var digest []byte
+		switch alg {
+		case "ES384":
+			sum := sha512.Sum384(input)
+			digest = sum[:]
+		case "ES512":
+			sum := sha512.Sum512(input)
+			digest = sum[:]
+		default:
+			sum := sha256.Sum256(input)
+			digest = sum[:]
+		}
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key %T", k.signer)
+}
+
+// JWK returns the public key in its JWKS form.
+func (k *Key) JWK() map[string]string {
+	jwk := map[string]string{"kid": k.ID, "use": "sig"}
+	if k.Algorithm != "" {
+		jwk["alg"] = k.Algorithm
+	}
+
+	switch key := k.signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = encodeBigInt(key.N)
+		jwk["e"] = encodeBigInt(big.NewInt(int64(key.E)))
+	case *ecdsa.PublicKey:
+		jwk["kty"] = "EC"
+		jwk["crv"] = key.Curve.Params().Name
+		size := (key.Curve.Params().BitSize + 7) / 8
+		point, _ := key.Bytes()
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(key)
+	}
+
+	return jwk
+}
+
+// Encode builds a token from header and claims, signed by sign, which may
+// return no signature, e.g. for "none" tokens.
+func Encode(header, claims any, sign func(input []byte) ([]byte, error)) (string, error) {
+	const op = "jwttest.Encode"
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to marshal header: %w", op, err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to marshal claims: %w", op, err)
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	signature, err := sign([]byte(input))
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to sign: %w", op, err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// Issuer serves an OpenID Connect discovery document and the JWKS of its
+// keys.
+type Issuer struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	keys         []*Key
+	failing      bool
+	jwksRequests int
+}
+
+// NewIssuer starts an issuer publishing keys.
+func NewIssuer(keys ...*Key) *Issuer {
+	i := &Issuer{keys: keys}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.handleDiscovery)
+	mux.HandleFunc("/jwks", i.handleJWKS)
+	i.server = httptest.NewServer(mux)
+
+	return i
+}
+
+// URL is the issuer identifier.
+func (i *Issuer) URL() string {
+	return i.server.URL
+}
+
+func (i *Issuer) JWKSURL() string {
+	return i.server.URL + "/jwks"
+}
+
+// SetKeys replaces the published keys, e.g. to rotate them.
+func (i *Issuer) SetKeys(keys ...*Key) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.keys = keys
+}
+
+// SetFailing makes the JWKS endpoint answer with an error.
+func (i *Issuer) SetFailing(failing bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.failing = failing
+}
+
+// JWKSRequests returns the number of JWKS requests served so far.
+func (i *Issuer) JWKSRequests() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.jwksRequests
+}
+
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+func (i *Issuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 i.URL(),
+		"authorization_endpoint": i.URL() + "/auth",
+		"token_endpoint":         i.URL() + "/token",
+		"userinfo_endpoint":      i.URL() + "/userinfo",
+		"revocation_endpoint":    i.URL() + "/revoke",
+		"jwks_uri":               i.JWKSURL(),
+	})
+}
+
+func (i *Issuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	i.jwksRequests++
+	failing := i.failing
+	keys := make([]map[string]string, 0, len(i.keys))
+	for _, key := range i.keys {
+		keys = append(keys, key.JWK())
+	}
+	i.mu.Unlock()
+
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
@@ -0,0 +1,176 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt"
+	"github.com/MaxRomanov007/smart-pc-go-lib/domain/models/user"
+	"golang.org/x/oauth2"
+)
+
+const idTokenLeeway = time.Minute
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// IDTokenClaims are the claims of an OpenID Connect ID token.
+type IDTokenClaims struct {
+	jwt.Claims
+	Nonce           string `json:"nonce"`
+	AuthTime        int64  `json:"auth_time"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	Name            string `json:"name"`
+}
+
+type IDToken struct {
+	Raw    string
+	Claims IDTokenClaims
+	// Info is the token payload decoded as a userinfo response.
+	Info *user.Info
+}
+
+type oidcProvider struct {
+	issuer string
+	keys   *jwt.KeySet
+}
+
+type oidcDiscovery struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	UserInfoEndpoint            string `json:"userinfo_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+	RevocationEndpoint          string `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// NewConfigFromIssuer reads the OpenID Connect discovery document of
+// issuerURL and returns a Config with all endpoints filled in and the
+// "openid" scope requested. ID tokens received by the flows of this Config
+// are validated, see Auth.IDToken. Add scopes such as "offline_access" to
+// Oauth2Config.Scopes as the provider requires.
+func NewConfigFromIssuer(ctx context.Context, issuerURL, clientID string) (*Config, error) {
+	const op = "lib.authorization.NewConfigFromIssuer"
+
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		issuerURL+"/.well-known/openid-configuration",
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to create request: %w", op, err)
+	}
+
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to get discovery document: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: discovery request failed, status: %s", op, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read body: %w", op, err)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.Unmarshal(body, &discovery); err != nil {
+		return nil, fmt.Errorf("%s: failed to unmarshal discovery document: %w", op, err)
+	}
+
+	// a mismatch means the document is not the issuer's own
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuerURL {
+		return nil, fmt.Errorf("%s: issuer mismatch: %q", op, discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%s: discovery document has no jwks_uri", op)
+	}
+
+	return &Config{
+		Oauth2Config: &oauth2.Config{
+			ClientID: clientID,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:       discovery.AuthorizationEndpoint,
+				TokenURL:      discovery.TokenEndpoint,
+				DeviceAuthURL: discovery.DeviceAuthorizationEndpoint,
+			},
+			Scopes: []string{"openid"},
+		},
+		UserInfoURL:   discovery.UserInfoEndpoint,
+		RevocationURL: discovery.RevocationEndpoint,
+		oidc: &oidcProvider{
+			issuer: discovery.Issuer,
+			keys:   jwt.NewKeySet(discovery.JWKSURI, &jwt.KeySetOptions{HTTPClient: httpClient(ctx)}),
+		},
+	}, nil
+}
+
+// VerifyIDToken checks the signature of raw against the provider keys,
+// its issuer, audience and expiry, and nonce unless it is empty. Only
+// configs created with NewConfigFromIssuer can verify ID tokens.
+func (cfg *Config) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	const op = "lib.authorization.VerifyIDToken"
+
+	if cfg.oidc == nil {
+		return nil, fmt.Errorf("%s: config is not created from an issuer", op)
+	}
+
+	var payload json.RawMessage
+	if _, err := jwt.Verify(ctx, raw, cfg.oidc.keys, &payload); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token := &IDToken{Raw: raw, Info: new(user.Info)}
+	if err := json.Unmarshal(payload, &token.Claims); err != nil {
+		return nil, fmt.Errorf("%s: failed to unmarshal claims: %w", op, err)
+	}
+	if err := json.Unmarshal(payload, token.Info); err != nil {
+		return nil, fmt.Errorf("%s: failed to unmarshal user info: %w", op, err)
+	}
+
+	claims := &token.Claims
+	if err := claims.Validate(jwt.Expected{
+		Issuer:   cfg.oidc.issuer,
+		Audience: cfg.Oauth2Config.ClientID,
+		Leeway:   idTokenLeeway,
+	}); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != cfg.Oauth2Config.ClientID {
+		return nil, fmt.Errorf("%s: authorized party mismatch: %w", op, ErrInvalidIDToken)
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, fmt.Errorf("%s: nonce mismatch: %w", op, ErrInvalidIDToken)
+	}
+
+	return token, nil
+}
+
+// verifyTokenIDToken verifies the ID token of a token response, if there is
+// one and the config can verify it.
+func (cfg *Config) verifyTokenIDToken(
+	ctx context.Context,
+	token *oauth2.Token,
+	nonce string,
+) (*IDToken, error) {
+	raw, _ := token.Extra("id_token").(string)
+	if cfg.oidc == nil || raw == "" {
+		return nil, nil
+	}
+
+	return cfg.VerifyIDToken(ctx, raw, nonce)
+}
@@ -0,0 +1,124 @@
+package authorization
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt"
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt/jwttest"
+)
+
+func TestNewConfigFromIssuer(t *testing.T) {
+	issuer := jwttest.NewIssuer()
+	defer issuer.Close()
+
+	cfg, err := NewConfigFromIssuer(t.Context(), issuer.URL()+"/", "client")
+	if err != nil {
+		t.Fatalf("NewConfigFromIssuer: %v", err)
+	}
+
+	if cfg.Oauth2Config.Endpoint.TokenURL != issuer.URL()+"/token" {
+		t.Errorf("token url = %q", cfg.Oauth2Config.Endpoint.TokenURL)
+	}
+	if cfg.UserInfoURL != issuer.URL()+"/userinfo" || cfg.RevocationURL != issuer.URL()+"/revoke" {
+		t.Errorf("userinfo url = %q, revocation url = %q", cfg.UserInfoURL, cfg.RevocationURL)
+	}
+	if len(cfg.Oauth2Config.Scopes) != 1 || cfg.Oauth2Config.Scopes[0] != "openid" {
+		t.Errorf("scopes = %v, want [openid]", cfg.Oauth2Config.Scopes)
+	}
+}
+
+func TestNewConfigFromIssuerMismatch(t *testing.T) {
+	// a document naming another issuer must not be trusted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issuer":"https://evil","jwks_uri":"https://evil/jwks"}`))
+	}))
+	defer server.Close()
+
+	if _, err := NewConfigFromIssuer(t.Context(), server.URL, "client"); err == nil {
+		t.Error("NewConfigFromIssuer accepted a foreign discovery document")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key, err := jwttest.NewKey("k1", "RS256")
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	issuer := jwttest.NewIssuer(key)
+	defer issuer.Close()
+
+	cfg, err := NewConfigFromIssuer(t.Context(), issuer.URL(), "client")
+	if err != nil {
+		t.Fatalf("NewConfigFromIssuer: %v", err)
+	}
+
+	claims := func(edit func(*IDTokenClaims)) IDTokenClaims {
+		c := IDTokenClaims{
+			Claims: jwt.Claims{
+				Issuer:   issuer.URL(),
+				Subject:  "u1",
+				Audience: jwt.Audience{"client"},
+				Expiry:   time.Now().Add(time.Hour).Unix(),
+				IssuedAt: time.Now().Unix(),
+			},
+			Nonce: "n1",
+			Email: "u1@example.com",
+		}
+		if edit != nil {
+			edit(&c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims IDTokenClaims
+		nonce  string
+		want   error
+	}{
+		{"valid", claims(nil), "n1", nil},
+		{"nonce not checked", claims(nil), "", nil},
+		{"azp with many audiences", claims(func(c *IDTokenClaims) {
+			c.Audience = jwt.Audience{"client", "api"}
+			c.AuthorizedParty = "client"
+		}), "n1", nil},
+		{"issuer mismatch", claims(func(c *IDTokenClaims) { c.Issuer = "https://evil" }), "n1", jwt.ErrInvalidIssuer},
+		{"audience mismatch", claims(func(c *IDTokenClaims) { c.Audience = jwt.Audience{"other"} }), "n1", jwt.ErrInvalidAudience},
+		{"azp missing with many audiences", claims(func(c *IDTokenClaims) {
+			c.Audience = jwt.Audience{"client", "api"}
+		}), "n1", ErrInvalidIDToken},
+		{"azp mismatch", claims(func(c *IDTokenClaims) {
+			c.Audience = jwt.Audience{"client", "api"}
+			c.AuthorizedParty = "api"
+		}), "n1", ErrInvalidIDToken},
+		{"nonce mismatch", claims(nil), "n2", ErrInvalidIDToken},
+		{"expired", claims(func(c *IDTokenClaims) { c.Expiry = time.Now().Add(-time.Hour).Unix() }), "n1", jwt.ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := key.Sign(tt.claims)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			token, err := cfg.VerifyIDToken(t.Context(), raw, tt.nonce)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Errorf("VerifyIDToken error = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if token.Claims.Subject != "u1" || token.Info.Sub != "u1" || token.Claims.Email != "u1@example.com" {
+				t.Errorf("token = %+v, want the claims of u1", token.Claims)
+			}
+		})
+	}
+}
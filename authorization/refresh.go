@@ -0,0 +1,118 @@
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const defaultRefreshTimeout = 30 * time.Second
+
+// refreshCall is a refresh in flight, shared by everyone waiting for it.
+type refreshCall struct {
+	done  chan struct{}
+	token *oauth2.Token
+	err   error
+}
+
+func (c *refreshCall) wait(ctx context.Context) (*oauth2.Token, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return c.token, c.err
+	}
+}
+
+// refreshDangerously returns the refresh in flight or starts one for the
+// current token. The refresh is not canceled with ctx, waiters give up
+// on their own, only the values of ctx are kept.
+func (a *Auth) refreshDangerously(ctx context.Context) *refreshCall {
+	if a.inflight != nil {
+		return a.inflight
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	a.inflight = call
+	go a.runRefresh(context.WithoutCancel(ctx), call, a.token)
+
+	return call
+}
+
+func (a *Auth) runRefresh(ctx context.Context, call *refreshCall, current *oauth2.Token) {
+	const op = "lib.authorization.runRefresh"
+
+	ctx, cancel := context.WithTimeout(ctx, defaultRefreshTimeout)
+	defer cancel()
+
+	refresh := a.cfg.refreshToken
+	if a.refresh != nil {
+		refresh = a.refresh
+	}
+
+	token, err := refresh(ctx, current)
+
+	emit := true
+	gen := 0
+	a.tokenMux.Lock()
+	a.inflight = nil
+	switch {
+	case err != nil:
+		if isPermanentRefreshError(err) {
+			err = fmt.Errorf("%w: %w", ErrReauthRequired, err)
+		}
+		call.err = fmt.Errorf("%s: %w", op, err)
+	case a.token != current:
+		// logged out meanwhile, the token must not come back
+		emit = false
+		call.err = fmt.Errorf("%s: %w", op, ErrNoToken)
+	default:
+		a.token = token
+		a.tokenGen++
+		gen = a.tokenGen
+		// same user, fetches in flight may still cache their result
+		a.userInfo = nil
+		call.token = token
+	}
+	a.tokenMux.Unlock()
+
+	// client credentials tokens are never saved
+	if call.err == nil && a.refresh == nil {
+		if err := a.saveToken(ctx, gen, token); err != nil {
+			call.err = fmt.Errorf("%s: failed to save token: %w", op, err)
+		}
+	}
+
+	// handlers see the event before the waiters return
+	defer close(call.done)
+
+	if !emit {
+		return
+	}
+	event := TokenEvent{Err: call.err, At: time.Now()}
+	if call.token != nil {
+		event.AccessToken = call.token.AccessToken
+		event.Expiry = call.token.Expiry
+	}
+	a.emit(event)
+}
+
+// saveToken saves token, which became the token of a with generation gen,
+// unless it was replaced or logged out since. The check and the save happen
+// under storeMux, so Logout deletes the token after any save it raced with.
+func (a *Auth) saveToken(ctx context.Context, gen int, token *oauth2.Token) error {
+	a.storeMux.Lock()
+	defer a.storeMux.Unlock()
+
+	a.tokenMux.Lock()
+	current := a.tokenGen == gen
+	a.tokenMux.Unlock()
+
+	if !current {
+		return nil
+	}
+
+	return a.cfg.saveTokenIfNeeded(ctx, token)
+}
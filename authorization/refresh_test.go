@@ -0,0 +1,141 @@
+package authorization
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTokenRefreshSingleFlight(t *testing.T) {
+	p := newProvider(t)
+	s := &store{}
+	auth := newAuth(p.config(s), expired())
+
+	arrived, release := p.gate("/token")
+	defer release()
+
+	const callers = 8
+	tokens := make(chan string, callers)
+	for range callers {
+		go func() {
+			token, err := auth.Token(t.Context())
+			if err != nil {
+				t.Errorf("Token: %v", err)
+			}
+			tokens <- token
+		}()
+	}
+
+	await(t, arrived, "refresh not started")
+	if _, err := auth.TryToken(t.Context()); !errors.Is(err, ErrTokenLocked) {
+		t.Errorf("TryToken during refresh error = %v, want %v", err, ErrTokenLocked)
+	}
+	release()
+
+	for range callers {
+		if token := await(t, tokens, "Token did not return"); token != "access-1" {
+			t.Errorf("Token = %q, want %q", token, "access-1")
+		}
+	}
+	if n := p.count("/token"); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+	if saved := s.saved(); saved == nil || saved.AccessToken != "access-1" {
+		t.Errorf("saved token = %+v, want access-1", saved)
+	}
+}
+
+func TestTokenRefreshSavesOutsideLock(t *testing.T) {
+	p := newProvider(t)
+
+	saving, release := make(chan struct{}), make(chan struct{})
+	s := &store{saving: func() {
+		saving <- struct{}{}
+		<-release
+	}}
+	auth := newAuth(p.config(s), expired())
+
+	refreshed := async(func() error {
+		_, err := auth.Token(t.Context())
+		return err
+	})
+	await(t, saving, "token not saved")
+
+	// the new token is served while the store is still busy
+	got := async(func() error {
+		token, err := auth.TryToken(t.Context())
+		if err == nil && token != "access-1" {
+			t.Errorf("TryToken = %q, want %q", token, "access-1")
+		}
+		return err
+	})
+	if err := await(t, got, "TryToken blocked by SaveToken"); err != nil {
+		t.Errorf("TryToken: %v", err)
+	}
+
+	close(release)
+	if err := await(t, refreshed, "Token did not return"); err != nil {
+		t.Errorf("Token: %v", err)
+	}
+}
+
+func TestLogoutDuringRefresh(t *testing.T) {
+	p := newProvider(t)
+	s := &store{}
+	auth := newAuth(p.config(s), expired())
+
+	arrived, release := p.gate("/token")
+	defer release()
+
+	refreshed := async(func() error {
+		_, err := auth.Token(t.Context())
+		return err
+	})
+	await(t, arrived, "refresh not started")
+
+	if err := auth.Logout(t.Context()); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	release()
+
+	if err := await(t, refreshed, "Token did not return"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Token error = %v, want %v", err, ErrNoToken)
+	}
+	if _, err := auth.Token(t.Context()); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Token after Logout error = %v, want %v", err, ErrNoToken)
+	}
+	if saved := s.saved(); saved != nil {
+		t.Errorf("refreshed token saved after Logout: %+v", saved)
+	}
+}
+
+func TestLogoutDuringSave(t *testing.T) {
+	p := newProvider(t)
+
+	saving, release := make(chan struct{}), make(chan struct{})
+	s := &store{saving: func() {
+		saving <- struct{}{}
+		<-release
+	}}
+	auth := newAuth(p.config(s), expired())
+
+	refreshed := async(func() error {
+		_, err := auth.Token(t.Context())
+		return err
+	})
+	await(t, saving, "token not saved")
+
+	// Logout revokes and then waits for the save to delete the token
+	loggedOut := async(func() error { return auth.Logout(t.Context()) })
+	eventually(t, func() bool { return p.count("/revoke") == 2 }, "tokens not revoked")
+
+	close(release)
+	if err := await(t, loggedOut, "Logout did not return"); err != nil {
+		t.Errorf("Logout: %v", err)
+	}
+	if err := await(t, refreshed, "Token did not return"); err != nil {
+		t.Errorf("Token: %v", err)
+	}
+	if saved := s.saved(); saved != nil {
+		t.Errorf("token left in the store after Logout: %+v", saved)
+	}
+}
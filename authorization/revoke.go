@@ -0,0 +1,119 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Logout revokes the tokens at the provider, deletes the saved token and
+// forgets it, so later Token calls return ErrNoToken. The token is
+// forgotten even if revocation fails. Token handlers get an event without a
+// token.
+func (a *Auth) Logout(ctx context.Context) error {
+	const op = "lib.authorization.Logout"
+
+	defer a.emit(TokenEvent{At: time.Now()})
+
+	// the token is forgotten first, so nobody waits for the revocation
+	a.tokenMux.Lock()
+	token := a.token
+	a.token = nil
+	a.tokenGen++
+	a.idToken = nil
+	a.invalidateUserInfoDangerously()
+	a.tokenMux.Unlock()
+
+	var errs []error
+
+	if token != nil && a.cfg.RevocationURL != "" {
+		// the refresh token goes first, revoking it usually revokes the
+		// access tokens issued with it too
+		if token.RefreshToken != "" {
+			if err := a.cfg.revoke(ctx, token.RefreshToken, "refresh_token"); err != nil {
+				errs = append(errs, fmt.Errorf("failed to revoke refresh token: %w", err))
+			}
+		}
+		if token.AccessToken != "" {
+			if err := a.cfg.revoke(ctx, token.AccessToken, "access_token"); err != nil {
+				errs = append(errs, fmt.Errorf("failed to revoke access token: %w", err))
+			}
+		}
+	}
+
+	// under storeMux a refresh racing with Logout saved its token already
+	// or sees the new generation and skips saving
+	a.storeMux.Lock()
+	if err := a.cfg.deleteTokenIfNeeded(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete token: %w", err))
+	}
+	a.storeMux.Unlock()
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// revoke sends an RFC 7009 revocation request.
+func (cfg *Config) revoke(ctx context.Context, token, hint string) error {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {hint},
+	}
+
+	oauth2Config := cfg.Oauth2Config
+	basicAuth := oauth2Config.ClientSecret != "" &&
+		oauth2Config.Endpoint.AuthStyle != oauth2.AuthStyleInParams
+	if !basicAuth {
+		form.Set("client_id", oauth2Config.ClientID)
+		if oauth2Config.ClientSecret != "" {
+			form.Set("client_secret", oauth2Config.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		cfg.RevocationURL,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicAuth {
+		req.SetBasicAuth(url.QueryEscape(oauth2Config.ClientID), url.QueryEscape(oauth2Config.ClientSecret))
+	}
+
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("revocation failed, status: %s: %s", resp.Status, body)
+	}
+
+	return nil
+}
+
+// httpClient returns the client set with oauth2.HTTPClient, like the oauth2
+// package does for its own requests.
+func httpClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		return client
+	}
+
+	return http.DefaultClient
+}
@@ -0,0 +1,143 @@
+package authorization
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		authStyle oauth2.AuthStyle
+		by        string
+	}{
+		{"public client", "", oauth2.AuthStyleInParams, "form:client:"},
+		{"secret in header", "s3cret", oauth2.AuthStyleInHeader, "basic:client:s3cret"},
+		{"secret detected", "s3cret", oauth2.AuthStyleAutoDetect, "basic:client:s3cret"},
+		{"secret in params", "s3cret", oauth2.AuthStyleInParams, "form:client:s3cret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider(t)
+			s := &store{}
+			cfg := p.config(s)
+			cfg.Oauth2Config.ClientSecret = tt.secret
+			cfg.Oauth2Config.Endpoint.AuthStyle = tt.authStyle
+			auth := newAuth(cfg, valid())
+			if err := s.Save(t.Context(), auth.token); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+
+			events := make(chan TokenEvent, 1)
+			auth.OnTokenChange(func(e TokenEvent) { events <- e })
+
+			if err := auth.Logout(t.Context()); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+
+			// the refresh token first, as revoking it usually revokes the
+			// access token too
+			p.mu.Lock()
+			revoked, revokedBy := p.revoked, p.revokedBy
+			p.mu.Unlock()
+			if want := []string{"refresh_token:refresh-0", "access_token:access-0"}; !slices.Equal(revoked, want) {
+				t.Errorf("revoked %v, want %v", revoked, want)
+			}
+			if want := []string{tt.by, tt.by}; !slices.Equal(revokedBy, want) {
+				t.Errorf("client authenticated as %v, want %v", revokedBy, want)
+			}
+
+			if s.saved() != nil {
+				t.Error("token left in the store")
+			}
+			if _, err := auth.Token(t.Context()); !errors.Is(err, ErrNoToken) {
+				t.Errorf("Token error = %v, want %v", err, ErrNoToken)
+			}
+			if e := await(t, events, "no token event"); e.AccessToken != "" || e.Err != nil {
+				t.Errorf("event = %+v, want one without a token", e)
+			}
+		})
+	}
+}
+
+func TestLogoutRevocationFails(t *testing.T) {
+	p := newProvider(t)
+	p.setRevokeStatus(http.StatusServiceUnavailable)
+	s := &store{}
+	auth := newAuth(p.config(s), valid())
+	if err := s.Save(t.Context(), auth.token); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if err := auth.Logout(t.Context()); err == nil {
+		t.Error("Logout hid the failed revocation")
+	}
+
+	// both tokens were tried and the token is forgotten all the same
+	if n := p.count("/revoke"); n != 2 {
+		t.Errorf("%d revocation requests, want 2", n)
+	}
+	if s.saved() != nil {
+		t.Error("token left in the store")
+	}
+	if _, err := auth.Token(t.Context()); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Token error = %v, want %v", err, ErrNoToken)
+	}
+}
+
+func TestLogoutWithoutRevocation(t *testing.T) {
+	p := newProvider(t)
+	s := &store{}
+	cfg := p.config(s)
+	cfg.RevocationURL = ""
+	auth := newAuth(cfg, valid())
+	if err := s.Save(t.Context(), auth.token); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if err := auth.Logout(t.Context()); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if n := p.count("/revoke"); n != 0 {
+		t.Errorf("%d revocation requests without a revocation url", n)
+	}
+	if s.saved() != nil {
+		t.Error("token left in the store")
+	}
+
+	// logging out twice is fine
+	if err := auth.Logout(t.Context()); err != nil {
+		t.Errorf("second Logout: %v", err)
+	}
+}
+
+func TestLogoutDoesNotBlockToken(t *testing.T) {
+	p := newProvider(t)
+	auth := newAuth(p.config(&store{}), valid())
+
+	arrived, release := p.gate("/revoke")
+	defer release()
+
+	loggedOut := async(func() error { return auth.Logout(t.Context()) })
+	await(t, arrived, "revocation not requested")
+
+	// the token is already forgotten while the provider is busy
+	got := async(func() error {
+		_, err := auth.TryToken(t.Context())
+		return err
+	})
+	if err := await(t, got, "TryToken blocked by Logout"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("TryToken error = %v, want %v", err, ErrNoToken)
+	}
+
+	release()
+	if err := await(t, loggedOut, "Logout did not return"); err != nil {
+		t.Errorf("Logout: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultRefreshSkew          = time.Minute
+	defaultRefreshRetryInterval = 10 * time.Second
+)
+
+// TokenEvent is emitted whenever the token changes or fails to refresh.
+type TokenEvent struct {
+	// AccessToken is the new token, empty after a failed refresh and after
+	// Logout.
+	AccessToken string
+	Expiry      time.Time
+	// Err is set when a refresh failed or its token could not be saved. It
+	// wraps ErrReauthRequired when retrying is pointless and the user has to
+	// log in again.
+	Err error
+	At  time.Time
+}
+
+type TokenHandler func(TokenEvent)
+
+type RefreshOptions struct {
+	// Skew is how long before the expiry the token is refreshed.
+	// Defaults to 1m.
+	Skew time.Duration
+	// RetryInterval is the delay before retrying a failed refresh.
+	// Defaults to 10s.
+	RetryInterval time.Duration
+}
+
+// OnTokenChange registers h for token events and returns a function
+// removing it. Handlers run synchronously, they must not block.
+func (a *Auth) OnTokenChange(h TokenHandler) func() {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	if a.handlers == nil {
+		a.handlers = make(map[int]TokenHandler)
+	}
+
+	id := a.nextHandlerID
+	a.nextHandlerID++
+	a.handlers[id] = h
+
+	return func() {
+		a.handlersMux.Lock()
+		defer a.handlersMux.Unlock()
+
+		delete(a.handlers, id)
+	}
+}
+
+// StartRefresh refreshes the token in the background before it expires, so
+// Token never waits for the provider, until ctx is done, the user logs out
+// or the refresh fails permanently. opts may be nil.
+func (a *Auth) StartRefresh(ctx context.Context, opts *RefreshOptions) {
+	skew, retryInterval := defaultRefreshSkew, defaultRefreshRetryInterval
+	if opts != nil && opts.Skew > 0 {
+		skew = opts.Skew
+	}
+	if opts != nil && opts.RetryInterval > 0 {
+		retryInterval = opts.RetryInterval
+	}
+
+	changed := make(chan struct{}, 1)
+	unsubscribe := a.OnTokenChange(func(TokenEvent) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	go func() {
+		defer unsubscribe()
+		a.refreshAhead(ctx, skew, retryInterval, changed)
+	}()
+}
+
+func (a *Auth) refreshAhead(
+	ctx context.Context,
+	skew, retryInterval time.Duration,
+	changed <-chan struct{},
+) {
+	for {
+		a.tokenMux.Lock()
+		token := a.token
+		a.tokenMux.Unlock()
+
+		if token == nil || token.Expiry.IsZero() {
+			// logged out or the token never expires
+			return
+		}
+
+		// tokens living shorter than skew are refreshed halfway instead of
+		// over and over again
+		lifetime := time.Until(token.Expiry)
+		timer := time.NewTimer(max(lifetime-skew, lifetime/2))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-changed:
+			// refreshed elsewhere, schedule for the new expiry
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		err := a.forceRefresh(ctx, token)
+		if errors.Is(err, ErrReauthRequired) {
+			return
+		}
+		if err == nil {
+			// drop our own change notification
+			select {
+			case <-changed:
+			default:
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// forceRefresh refreshes the token unless it was already replaced since
+// current was read. Token keeps returning current meanwhile.
+func (a *Auth) forceRefresh(ctx context.Context, current *oauth2.Token) error {
+	a.tokenMux.Lock()
+	if a.token != current {
+		a.tokenMux.Unlock()
+		return nil
+	}
+	call := a.refreshDangerously(ctx)
+	a.tokenMux.Unlock()
+
+	_, err := call.wait(ctx)
+	return err
+}
+
+func (a *Auth) emit(event TokenEvent) {
+	a.handlersMux.Lock()
+	handlers := make([]TokenHandler, 0, len(a.handlers))
+	for _, h := range a.handlers {
+		handlers = append(handlers, h)
+	}
+	a.handlersMux.Unlock()
+
+	for _, h := range handlers {
+		h(event)
+	}
+}
+
+// isPermanentRefreshError reports whether the provider rejected the grant
+// itself, e.g. a revoked or expired refresh token.
+func isPermanentRefreshError(err error) bool {
+	retrieveErr, ok := errors.AsType[*oauth2.RetrieveError](err)
+	if !ok {
+		return false
+	}
+
+	return slices.Contains(
+		[]string{"invalid_grant", "invalid_client", "unauthorized_client", "unsupported_grant_type"},
+		retrieveErr.ErrorCode,
+	)
+}
@@ -0,0 +1,209 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects the token events of an Auth.
+type recorder struct {
+	mu     sync.Mutex
+	events []TokenEvent
+	ch     chan TokenEvent
+}
+
+func record(a *Auth) *recorder {
+	r := &recorder{ch: make(chan TokenEvent, 16)}
+	a.OnTokenChange(func(e TokenEvent) {
+		r.mu.Lock()
+		r.events = append(r.events, e)
+		r.mu.Unlock()
+		r.ch <- e
+	})
+
+	return r
+}
+
+func (r *recorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.events)
+}
+
+func handlerCount(a *Auth) int {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	return len(a.handlers)
+}
+
+func TestTokenEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokenErr string
+		access   string
+		reauth   bool
+	}{
+		{"refreshed", "", "access-1", false},
+		{"transient failure", "temporarily_unavailable", "", false},
+		{"grant revoked", "invalid_grant", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider(t)
+			p.setTokenError(tt.tokenErr)
+			auth := newAuth(p.config(&store{}), expired())
+			r := record(auth)
+
+			_, err := auth.Token(t.Context())
+
+			// handlers ran before the waiter returned
+			if n := r.len(); n != 1 {
+				t.Fatalf("%d events when Token returned, want 1", n)
+			}
+			e := <-r.ch
+			if e.AccessToken != tt.access || (e.Err != nil) != (tt.tokenErr != "") || e.At.IsZero() {
+				t.Errorf("event = %+v", e)
+			}
+			if errors.Is(e.Err, ErrReauthRequired) != tt.reauth || errors.Is(err, ErrReauthRequired) != tt.reauth {
+				t.Errorf("event error = %v, Token error = %v, reauth required %v", e.Err, err, tt.reauth)
+			}
+			if tt.access != "" && !e.Expiry.After(time.Now()) {
+				t.Errorf("event expiry = %v", e.Expiry)
+			}
+		})
+	}
+}
+
+func TestOnTokenChangeUnsubscribe(t *testing.T) {
+	p := newProvider(t)
+	auth := newAuth(p.config(&store{}), expired())
+
+	r := record(auth)
+	unsubscribed := 0
+	unsubscribe := auth.OnTokenChange(func(TokenEvent) { unsubscribed++ })
+	unsubscribe()
+
+	if _, err := auth.Token(t.Context()); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if err := auth.Logout(t.Context()); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+
+	if n := r.len(); n != 2 {
+		t.Errorf("%d events, want the refresh and the logout", n)
+	}
+	if unsubscribed != 0 {
+		t.Errorf("removed handler called %d times", unsubscribed)
+	}
+}
+
+func TestStartRefresh(t *testing.T) {
+	p := newProvider(t)
+	s := &store{}
+	auth := newAuth(p.config(s), time.Now().Add(200*time.Millisecond))
+	r := record(auth)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	auth.StartRefresh(ctx, &RefreshOptions{Skew: 150 * time.Millisecond})
+
+	e := await(t, r.ch, "token not refreshed ahead")
+	if e.AccessToken != "access-1" || e.Err != nil {
+		t.Errorf("event = %+v, want access-1", e)
+	}
+	if saved := s.saved(); saved == nil || saved.AccessToken != "access-1" {
+		t.Errorf("saved token = %+v, want access-1", saved)
+	}
+
+	// the new token lives an hour, nothing to do for now
+	time.Sleep(100 * time.Millisecond)
+	if n := p.count("/token"); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+
+	cancel()
+	eventually(t, func() bool { return handlerCount(auth) == 1 }, "refresh-ahead kept running after ctx was done")
+}
+
+func TestStartRefreshReschedules(t *testing.T) {
+	p := newProvider(t)
+	auth := newAuth(p.config(&store{}), time.Now().Add(time.Hour))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	auth.StartRefresh(ctx, &RefreshOptions{Skew: 150 * time.Millisecond})
+
+	// a token about to expire arrives from elsewhere, e.g. a new login
+	if err := auth.replaceToken(t.Context(), newAuth(nil, time.Now().Add(200*time.Millisecond)).token, nil); err != nil {
+		t.Fatalf("replaceToken: %v", err)
+	}
+
+	eventually(t, func() bool { return p.count("/token") == 1 }, "new token not refreshed ahead")
+}
+
+func TestStartRefreshRetries(t *testing.T) {
+	p := newProvider(t)
+	p.setTokenError("temporarily_unavailable")
+	auth := newAuth(p.config(&store{}), time.Now().Add(50*time.Millisecond))
+	r := record(auth)
+
+	auth.StartRefresh(t.Context(), &RefreshOptions{RetryInterval: 20 * time.Millisecond})
+
+	for range 2 {
+		if e := await(t, r.ch, "refresh not retried"); e.Err == nil {
+			t.Fatalf("event = %+v, want a failure", e)
+		}
+	}
+
+	p.setTokenError("")
+	for {
+		e := await(t, r.ch, "refresh not retried after the provider recovered")
+		if e.Err == nil {
+			if e.AccessToken == "" {
+				t.Errorf("event = %+v, want a token", e)
+			}
+			break
+		}
+	}
+}
+
+func TestStartRefreshStops(t *testing.T) {
+	t.Run("reauth required", func(t *testing.T) {
+		p := newProvider(t)
+		p.setTokenError("invalid_grant")
+		auth := newAuth(p.config(&store{}), time.Now().Add(50*time.Millisecond))
+		r := record(auth)
+
+		auth.StartRefresh(t.Context(), &RefreshOptions{RetryInterval: 10 * time.Millisecond})
+
+		if e := await(t, r.ch, "refresh not attempted"); !errors.Is(e.Err, ErrReauthRequired) {
+			t.Errorf("event error = %v, want %v", e.Err, ErrReauthRequired)
+		}
+		eventually(t, func() bool { return handlerCount(auth) == 1 }, "refresh-ahead kept running")
+		if n := p.count("/token"); n != 1 {
+			t.Errorf("%d token requests, want no retry", n)
+		}
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		p := newProvider(t)
+		auth := newAuth(p.config(&store{}), time.Now().Add(time.Hour))
+
+		auth.StartRefresh(t.Context(), nil)
+		if err := auth.Logout(t.Context()); err != nil {
+			t.Fatalf("Logout: %v", err)
+		}
+
+		eventually(t, func() bool { return handlerCount(auth) == 0 }, "refresh-ahead kept running")
+		if n := p.count("/token"); n != 0 {
+			t.Errorf("%d token requests after Logout", n)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package tokenStore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+	userScope "github.com/MaxRomanov007/smart-pc-go-lib/user-scope"
+)
+
+var _ authorization.AccountIndex = (*IndexFile)(nil)
+
+// AccountFiles returns an authorization.AccountsOptions.Store keeping every
+// account in its own file next to opts.Path, all sharing one key.
+func AccountFiles(opts *FileOptions) func(sub string) (authorization.TokenStore, error) {
+	shared := *opts
+	if shared.KeyFile == "" && shared.Passphrase == "" {
+		shared.KeyFile = shared.Path + keyFileSuffix
+	}
+
+	return func(sub string) (authorization.TokenStore, error) {
+		accountOpts := shared
+		if shared.Path != "" {
+			// the subject may contain anything, file names may not
+			accountOpts.Path = shared.Path + "." +
+				userScope.CachePath(base64.RawURLEncoding.EncodeToString([]byte(sub)))
+		}
+
+		return NewFile(&accountOpts)
+	}
+}
+
+// IndexFile keeps the account list as JSON in a file readable only by the
+// current user.
+type IndexFile struct {
+	path userScope.CachePath
+	mu   sync.Mutex
+}
+
+func NewIndexFile(path userScope.CachePath) *IndexFile {
+	return &IndexFile{path: path}
+}
+
+func (f *IndexFile) Load(context.Context) (*authorization.AccountList, error) {
+	const op = "token-store.accounts.IndexFile.Load"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	list := new(authorization.AccountList)
+
+	data, err := os.ReadFile(string(f.path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read file: %w", op, err)
+	}
+
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("%s: failed to unmarshal accounts: %w", op, err)
+	}
+
+	return list, nil
+}
+
+func (f *IndexFile) Save(_ context.Context, list *authorization.AccountList) error {
+	const op = "token-store.accounts.IndexFile.Save"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal accounts: %w", op, err)
+	}
+
+	if err := writeFileAtomic(string(f.path), data); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
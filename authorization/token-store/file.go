@@ -0,0 +1,354 @@
+package tokenStore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+	userScope "github.com/MaxRomanov007/smart-pc-go-lib/user-scope"
+	"golang.org/x/oauth2"
+)
+
+const (
+	fileVersion       = 1
+	keySize           = 32
+	saltSize          = 16
+	pbkdf2Iterations  = 600_000
+	keyFileSuffix     = ".key"
+	filePermissions   = 0o600
+	folderPermissions = 0o700
+)
+
+var ErrInvalidKeyFile = errors.New("invalid key file")
+
+var _ authorization.TokenStore = (*File)(nil)
+
+type FileOptions struct {
+	Path userScope.CachePath
+	// KeyFile holds the encryption key and is created with a random key if
+	// missing. Defaults to Path with the ".key" suffix.
+	KeyFile userScope.CachePath
+	// Passphrase derives the key instead of the key file, so nothing
+	// secret is stored on disk.
+	Passphrase string
+}
+
+func (o *FileOptions) check() error {
+	errs := make([]error, 0, 2)
+
+	if o.Path == "" {
+		errs = append(errs, errors.New("path required"))
+	}
+	if o.KeyFile != "" && o.Passphrase != "" {
+		errs = append(errs, errors.New("key file and passphrase are mutually exclusive"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// File keeps the token encrypted with AES-GCM in a file readable only by
+// the current user. Writes are atomic, so a crash never leaves a partial
+// token behind.
+type File struct {
+	opts FileOptions
+
+	mu sync.Mutex
+	// derived passphrase key and its salt, cached as derivation is slow
+	salt []byte
+	key  []byte
+}
+
+func NewFile(opts *FileOptions) (*File, error) {
+	const op = "token-store.file.NewFile"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	f := &File{opts: *opts}
+	if f.opts.KeyFile == "" && f.opts.Passphrase == "" {
+		f.opts.KeyFile = f.opts.Path + keyFileSuffix
+	}
+
+	return f, nil
+}
+
+func (f *File) Load(context.Context) (*oauth2.Token, error) {
+	const op = "token-store.file.Load"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(string(f.opts.Path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", op, authorization.ErrNoToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read file: %w", op, err)
+	}
+
+	plain, err := f.decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to decrypt token: %w", op, err)
+	}
+
+	token := new(oauth2.Token)
+	if err := json.Unmarshal(plain, token); err != nil {
+		return nil, fmt.Errorf("%s: failed to unmarshal token: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (f *File) Save(_ context.Context, token *oauth2.Token) error {
+	const op = "token-store.file.Save"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal token: %w", op, err)
+	}
+
+	data, err := f.encrypt(plain)
+	if err != nil {
+		return fmt.Errorf("%s: failed to encrypt token: %w", op, err)
+	}
+
+	if err := writeFileAtomic(string(f.opts.Path), data); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Delete removes the token, the key file is kept for the next one.
+func (f *File) Delete(context.Context) error {
+	const op = "token-store.file.Delete"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.Remove(string(f.opts.Path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: failed to remove file: %w", op, err)
+	}
+
+	return nil
+}
+
+// encrypt returns version | [salt] | nonce | ciphertext, the salt being
+// present with a passphrase only.
+func (f *File) encrypt(plain []byte) ([]byte, error) {
+	header := []byte{fileVersion}
+
+	var key []byte
+	if f.opts.Passphrase != "" {
+		if f.key == nil {
+			salt := make([]byte, saltSize)
+			if _, err := rand.Read(salt); err != nil {
+				return nil, fmt.Errorf("failed to generate salt: %w", err)
+			}
+			if err := f.deriveKey(salt); err != nil {
+				return nil, err
+			}
+		}
+		key = f.key
+		header = append(header, f.salt...)
+	} else {
+		var err error
+		if key, err = loadOrCreateKey(string(f.opts.KeyFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	data := append(header, nonce...)
+	return gcm.Seal(data, nonce, plain, header), nil
+}
+
+func (f *File) decrypt(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != fileVersion {
+		return nil, errors.New("unsupported file format")
+	}
+	headerSize := 1
+
+	var key []byte
+	if f.opts.Passphrase != "" {
+		if len(data) < 1+saltSize {
+			return nil, errors.New("file too short")
+		}
+		salt := data[1 : 1+saltSize]
+		if f.key == nil || string(salt) != string(f.salt) {
+			if err := f.deriveKey(salt); err != nil {
+				return nil, err
+			}
+		}
+		key = f.key
+		headerSize += saltSize
+	} else {
+		var err error
+		if key, err = readKey(string(f.opts.KeyFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < headerSize+gcm.NonceSize() {
+		return nil, errors.New("file too short")
+	}
+	header := data[:headerSize]
+	nonce := data[headerSize : headerSize+gcm.NonceSize()]
+
+	return gcm.Open(nil, nonce, data[headerSize+gcm.NonceSize():], header)
+}
+
+func (f *File) deriveKey(salt []byte) error {
+	key, err := pbkdf2.Key(sha256.New, f.opts.Passphrase, salt, pbkdf2Iterations, keySize)
+	if err != nil {
+		return fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	f.salt = append([]byte(nil), salt...)
+	f.key = key
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func readKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("%s: %w", path, ErrInvalidKeyFile)
+	}
+
+	return key, nil
+}
+
+// loadOrCreateKey reads the key file, creating it with a random key if
+// missing. The key is written to a temporary file first and linked into
+// place, so a crash never leaves a truncated key and concurrent processes
+// agree on one key.
+func loadOrCreateKey(path string) ([]byte, error) {
+	key, err := readKey(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return key, err
+	}
+
+	key = make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	tmp, err := writeTemp(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	// unlike rename, link fails if the key file exists
+	err = os.Link(tmp, path)
+	if errors.Is(err, fs.ErrExist) {
+		// created concurrently by another process
+		return readKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key file: %w", err)
+	}
+
+	return key, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	return nil
+}
+
+// writeTemp writes data to a synced temporary file next to path and returns
+// its name, the caller removes it.
+func writeTemp(path string, data []byte) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, folderPermissions); err != nil {
+		return "", fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tmp.Close()
+
+	err = writeSynced(tmp, data)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+func writeSynced(tmp *os.File, data []byte) error {
+	if err := tmp.Chmod(filePermissions); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	return nil
+}
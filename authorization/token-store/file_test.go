@@ -0,0 +1,245 @@
+package tokenStore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+	userScope "github.com/MaxRomanov007/smart-pc-go-lib/user-scope"
+	"golang.org/x/oauth2"
+)
+
+func newFile(t *testing.T, opts FileOptions) *File {
+	t.Helper()
+
+	f, err := NewFile(&opts)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+
+	return f
+}
+
+func tempPath(t *testing.T) userScope.CachePath {
+	t.Helper()
+
+	return userScope.CachePath(filepath.Join(t.TempDir(), "auth", "token"))
+}
+
+func testToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour).Round(time.Second),
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(path userScope.CachePath) FileOptions
+	}{
+		{"key file", func(path userScope.CachePath) FileOptions {
+			return FileOptions{Path: path}
+		}},
+		{"passphrase", func(path userScope.CachePath) FileOptions {
+			return FileOptions{Path: path, Passphrase: "secret"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tempPath(t)
+			want := testToken()
+
+			if err := newFile(t, tt.opts(path)).Save(t.Context(), want); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+
+			// a fresh store has nothing cached
+			got, err := newFile(t, tt.opts(path)).Load(t.Context())
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if got.AccessToken != want.AccessToken || got.RefreshToken != want.RefreshToken || !got.Expiry.Equal(want.Expiry) {
+				t.Errorf("Load = %+v, want %+v", got, want)
+			}
+
+			info, err := os.Stat(string(path))
+			if err != nil {
+				t.Fatalf("stat token file: %v", err)
+			}
+			if perm := info.Mode().Perm(); perm != filePermissions {
+				t.Errorf("token file permissions = %o, want %o", perm, filePermissions)
+			}
+		})
+	}
+}
+
+func TestFileLoadMissing(t *testing.T) {
+	f := newFile(t, FileOptions{Path: tempPath(t)})
+
+	if _, err := f.Load(t.Context()); !errors.Is(err, authorization.ErrNoToken) {
+		t.Errorf("Load error = %v, want %v", err, authorization.ErrNoToken)
+	}
+}
+
+func TestFileTampered(t *testing.T) {
+	path := tempPath(t)
+	f := newFile(t, FileOptions{Path: path, Passphrase: "secret"})
+	if err := f.Save(t.Context(), testToken()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	data, err := os.ReadFile(string(path))
+	if err != nil {
+		t.Fatalf("read token file: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		index int
+	}{
+		{"version", 0},
+		{"salt", 1},
+		{"nonce", 1 + saltSize},
+		{"ciphertext", len(data) - 20},
+		{"tag", len(data) - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := append([]byte(nil), data...)
+			tampered[tt.index] ^= 0x01
+			if err := os.WriteFile(string(path), tampered, filePermissions); err != nil {
+				t.Fatalf("write token file: %v", err)
+			}
+
+			if token, err := f.Load(t.Context()); err == nil {
+				t.Errorf("Load of a tampered file = %+v", token)
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		if err := os.WriteFile(string(path), data[:1+saltSize+4], filePermissions); err != nil {
+			t.Fatalf("write token file: %v", err)
+		}
+
+		if _, err := f.Load(t.Context()); err == nil {
+			t.Error("Load of a truncated file succeeded")
+		}
+	})
+}
+
+func TestFileWrongKey(t *testing.T) {
+	t.Run("passphrase", func(t *testing.T) {
+		path := tempPath(t)
+		if err := newFile(t, FileOptions{Path: path, Passphrase: "secret"}).Save(t.Context(), testToken()); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		if _, err := newFile(t, FileOptions{Path: path, Passphrase: "other"}).Load(t.Context()); err == nil {
+			t.Error("Load with another passphrase succeeded")
+		}
+	})
+
+	t.Run("key file", func(t *testing.T) {
+		path := tempPath(t)
+		if err := newFile(t, FileOptions{Path: path}).Save(t.Context(), testToken()); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		// a key file of another installation
+		otherKey := userScope.CachePath(filepath.Join(t.TempDir(), "other.key"))
+		if err := newFile(t, FileOptions{Path: tempPath(t), KeyFile: otherKey}).Save(t.Context(), testToken()); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		if _, err := newFile(t, FileOptions{Path: path, KeyFile: otherKey}).Load(t.Context()); err == nil {
+			t.Error("Load with another key file succeeded")
+		}
+	})
+
+	t.Run("invalid key file", func(t *testing.T) {
+		path := tempPath(t)
+		f := newFile(t, FileOptions{Path: path})
+		if err := f.Save(t.Context(), testToken()); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if err := os.WriteFile(string(path)+keyFileSuffix, []byte("short"), filePermissions); err != nil {
+			t.Fatalf("write key file: %v", err)
+		}
+
+		if _, err := f.Load(t.Context()); !errors.Is(err, ErrInvalidKeyFile) {
+			t.Errorf("Load error = %v, want %v", err, ErrInvalidKeyFile)
+		}
+		if err := f.Save(t.Context(), testToken()); !errors.Is(err, ErrInvalidKeyFile) {
+			t.Errorf("Save error = %v, want %v", err, ErrInvalidKeyFile)
+		}
+	})
+}
+
+func TestFileConcurrentKeyCreation(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := userScope.CachePath(filepath.Join(dir, "shared.key"))
+
+	// stores of separate processes sharing one key file
+	var wg sync.WaitGroup
+	paths := make([]userScope.CachePath, 8)
+	for i := range paths {
+		paths[i] = userScope.CachePath(filepath.Join(dir, "token", string(rune('a'+i))))
+		wg.Go(func() {
+			if err := newFile(t, FileOptions{Path: paths[i], KeyFile: keyFile}).Save(t.Context(), testToken()); err != nil {
+				t.Errorf("Save: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	for _, path := range paths {
+		if _, err := newFile(t, FileOptions{Path: path, KeyFile: keyFile}).Load(t.Context()); err != nil {
+			t.Errorf("Load %s: %v", filepath.Base(string(path)), err)
+		}
+	}
+
+	info, err := os.Stat(string(keyFile))
+	if err != nil {
+		t.Fatalf("stat key file: %v", err)
+	}
+	if info.Size() != keySize || info.Mode().Perm() != filePermissions {
+		t.Errorf("key file has %d bytes and permissions %o", info.Size(), info.Mode().Perm())
+	}
+
+	temps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(temps) > 0 {
+		t.Errorf("temp files left behind: %v", temps)
+	}
+}
+
+func TestFileDeleteKeepsKey(t *testing.T) {
+	path := tempPath(t)
+	f := newFile(t, FileOptions{Path: path})
+	if err := f.Save(t.Context(), testToken()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if err := f.Delete(t.Context()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := f.Delete(t.Context()); err != nil {
+		t.Errorf("Delete of a missing token: %v", err)
+	}
+
+	if _, err := f.Load(t.Context()); !errors.Is(err, authorization.ErrNoToken) {
+		t.Errorf("Load error = %v, want %v", err, authorization.ErrNoToken)
+	}
+	if _, err := os.Stat(string(path) + keyFileSuffix); err != nil {
+		t.Errorf("key file removed: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package tokenStore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+	"golang.org/x/oauth2"
+)
+
+var _ authorization.TokenStore = (*Memory)(nil)
+
+// Memory keeps the token in memory only, e.g. for tests.
+type Memory struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// NewMemory returns a store holding token, which may be nil.
+func NewMemory(token *oauth2.Token) *Memory {
+	m := &Memory{}
+	if token != nil {
+		t := *token
+		m.token = &t
+	}
+
+	return m
+}
+
+func (m *Memory) Load(context.Context) (*oauth2.Token, error) {
+	const op = "token-store.memory.Load"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == nil {
+		return nil, fmt.Errorf("%s: %w", op, authorization.ErrNoToken)
+	}
+
+	token := *m.token
+	return &token, nil
+}
+
+func (m *Memory) Save(_ context.Context, token *oauth2.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := *token
+	m.token = &t
+
+	return nil
+}
+
+func (m *Memory) Delete(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.token = nil
+
+	return nil
+}
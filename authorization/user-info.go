@@ -0,0 +1,101 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/domain/models/user"
+	"golang.org/x/oauth2"
+)
+
+const defaultUserInfoTTL = 5 * time.Minute
+
+// FetchUserInfo returns the user info of the token owner. It is cached for
+// Config.UserInfoTTL and until the token changes, see InvalidateUserInfo.
+// The returned Info must not be modified.
+func (a *Auth) FetchUserInfo(ctx context.Context) (*user.Info, error) {
+	const op = "lib.authorization.FetchUserInfo"
+
+	ttl := a.cfg.UserInfoTTL
+	if ttl == 0 {
+		ttl = defaultUserInfoTTL
+	}
+
+	a.tokenMux.Lock()
+	if a.token == nil {
+		a.tokenMux.Unlock()
+		return nil, fmt.Errorf("%s: %w", op, ErrNoToken)
+	}
+	if a.userInfo != nil && time.Since(a.userInfoAt) < ttl {
+		info := a.userInfo
+		a.tokenMux.Unlock()
+		return info, nil
+	}
+	gen := a.userInfoGen
+	a.tokenMux.Unlock()
+
+	info, err := a.fetchUserInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.tokenMux.Lock()
+	// a token change meanwhile may have made info stale, e.g. after Logout
+	if gen == a.userInfoGen && ttl > 0 {
+		a.userInfo = info
+		a.userInfoAt = time.Now()
+	}
+	a.tokenMux.Unlock()
+
+	return info, nil
+}
+
+// InvalidateUserInfo drops the cached user info, e.g. after the user updated
+// the profile.
+func (a *Auth) InvalidateUserInfo() {
+	a.tokenMux.Lock()
+	defer a.tokenMux.Unlock()
+
+	a.invalidateUserInfoDangerously()
+}
+
+func (a *Auth) invalidateUserInfoDangerously() {
+	a.userInfo = nil
+	a.userInfoGen++
+}
+
+func (a *Auth) fetchUserInfo(ctx context.Context) (*user.Info, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// through the token source of a, so a refresh here is shared and saved
+	client := oauth2.NewClient(ctx, a.TokenSource(ctx))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo request failed, status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	info := new(user.Info)
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user info: %w", err)
+	}
+
+	return info, nil
+}
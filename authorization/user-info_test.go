@@ -0,0 +1,155 @@
+package authorization
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func (p *provider) setSub(sub string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sub = sub
+}
+
+func fetchSub(t *testing.T, a *Auth) string {
+	t.Helper()
+
+	info, err := a.FetchUserInfo(t.Context())
+	if err != nil {
+		t.Fatalf("FetchUserInfo: %v", err)
+	}
+
+	return info.Sub
+}
+
+func TestFetchUserInfoCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		requests int
+	}{
+		{"default ttl", 0, 1},
+		{"disabled", -1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider(t)
+			cfg := p.config(&store{})
+			cfg.UserInfoTTL = tt.ttl
+			auth := newAuth(cfg, valid())
+
+			for range 3 {
+				if sub := fetchSub(t, auth); sub != "u1" {
+					t.Errorf("sub = %q, want u1", sub)
+				}
+			}
+			if n := p.count("/userinfo"); n != tt.requests {
+				t.Errorf("%d userinfo requests, want %d", n, tt.requests)
+			}
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		p := newProvider(t)
+		cfg := p.config(&store{})
+		cfg.UserInfoTTL = 20 * time.Millisecond
+		auth := newAuth(cfg, valid())
+
+		fetchSub(t, auth)
+		time.Sleep(30 * time.Millisecond)
+		fetchSub(t, auth)
+
+		if n := p.count("/userinfo"); n != 2 {
+			t.Errorf("%d userinfo requests, want 2", n)
+		}
+	})
+}
+
+func TestFetchUserInfoToken(t *testing.T) {
+	p := newProvider(t)
+	s := &store{}
+	auth := newAuth(p.config(s), expired())
+
+	// the expired token is refreshed through the Auth and saved
+	fetchSub(t, auth)
+	if saved := s.saved(); saved == nil || saved.AccessToken != "access-1" {
+		t.Errorf("saved token = %+v, want access-1", saved)
+	}
+
+	// a refreshed token drops the cached info
+	expire(auth)
+	if _, err := auth.Token(t.Context()); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	fetchSub(t, auth)
+
+	p.mu.Lock()
+	bearers := p.bearers
+	p.mu.Unlock()
+	if want := []string{"Bearer access-1", "Bearer access-2"}; !slices.Equal(bearers, want) {
+		t.Errorf("userinfo requested with %v, want %v", bearers, want)
+	}
+
+	if err := auth.Logout(t.Context()); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, err := auth.FetchUserInfo(t.Context()); !errors.Is(err, ErrNoToken) {
+		t.Errorf("FetchUserInfo after Logout error = %v, want %v", err, ErrNoToken)
+	}
+}
+
+func TestFetchUserInfoStaleResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, a *Auth)
+	}{
+		{"invalidated", func(t *testing.T, a *Auth) {
+			a.InvalidateUserInfo()
+		}},
+		{"logged out and in again", func(t *testing.T, a *Auth) {
+			if err := a.Logout(t.Context()); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+			token := &oauth2.Token{AccessToken: "other", Expiry: valid()}
+			if err := a.replaceToken(t.Context(), token, nil); err != nil {
+				t.Fatalf("replaceToken: %v", err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider(t)
+			auth := newAuth(p.config(&store{}), valid())
+
+			arrived, release := p.gate("/userinfo")
+			fetched := async(func() error {
+				_, err := auth.FetchUserInfo(t.Context())
+				return err
+			})
+			await(t, arrived, "userinfo not requested")
+
+			// the fetch in flight answers for the old state, it must not
+			// be cached for the new one
+			tt.change(t, auth)
+			p.setSub("u2")
+			release()
+			if err := await(t, fetched, "FetchUserInfo did not return"); err != nil {
+				t.Fatalf("FetchUserInfo: %v", err)
+			}
+
+			if sub := fetchSub(t, auth); sub != "u2" {
+				t.Errorf("sub = %q, want the fresh u2", sub)
+			}
+			if n := p.count("/userinfo"); n != 2 {
+				t.Errorf("%d userinfo requests, want 2", n)
+			}
+		})
+	}
+}
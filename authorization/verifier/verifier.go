@@ -0,0 +1,161 @@
+// Package verifier validates JWT access tokens on resource servers, the
+// counterpart of the tokens apiclient.Client sends.
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt"
+)
+
+const defaultLeeway = time.Minute
+
+var (
+	ErrMissingScope = errors.New("missing scope")
+	ErrInvalidType  = errors.New("invalid token type")
+)
+
+// accessTokenTypes are the typ headers of access tokens: "at+jwt" of
+// RFC 9068 and the plain "JWT" providers issued before it.
+var accessTokenTypes = []string{"at+jwt", "jwt"}
+
+type Options struct {
+	Issuer  string
+	JWKSURL string
+	// Audience is required, tokens issued for other resource servers are
+	// rejected. Set SkipAudienceCheck to accept any audience instead.
+	Audience          string
+	SkipAudienceCheck bool
+	// RequiredScopes must all be granted to every token.
+	RequiredScopes []string
+	// Leeway allows for clock skew. Defaults to 1m.
+	Leeway time.Duration
+	// KeySet tunes fetching and caching of the keys, may be nil.
+	KeySet *jwt.KeySetOptions
+}
+
+func (o *Options) check() error {
+	errs := make([]error, 0, 3)
+
+	if o.Issuer == "" {
+		errs = append(errs, errors.New("issuer required"))
+	}
+	if o.JWKSURL == "" {
+		errs = append(errs, errors.New("jwks url required"))
+	}
+	if o.Audience == "" && !o.SkipAudienceCheck {
+		errs = append(errs, errors.New("audience required unless skip audience check is set"))
+	}
+	if o.Audience != "" && o.SkipAudienceCheck {
+		errs = append(errs, errors.New("audience and skip audience check are mutually exclusive"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Claims of an access token. Scopes are read from the "scope" string of
+// RFC 9068 and from the "scp" array some providers use instead.
+type Claims struct {
+	jwt.Claims
+	Scope    string   `json:"scope"`
+	Scp      []string `json:"scp"`
+	ClientID string   `json:"client_id"`
+	// Extra holds every claim, for the provider specific ones.
+	Extra map[string]any `json:"-"`
+}
+
+func (c *Claims) Scopes() []string {
+	scopes := strings.Fields(c.Scope)
+	for _, scope := range c.Scp {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+func (c *Claims) HasScopes(scopes ...string) bool {
+	granted := c.Scopes()
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type Verifier struct {
+	opts Options
+	keys *jwt.KeySet
+}
+
+func New(opts *Options) (*Verifier, error) {
+	const op = "verifier.New"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	v := &Verifier{
+		opts: *opts,
+		keys: jwt.NewKeySet(opts.JWKSURL, opts.KeySet),
+	}
+	if v.opts.Leeway <= 0 {
+		v.opts.Leeway = defaultLeeway
+	}
+
+	return v, nil
+}
+
+// Verify checks the signature, type, issuer, audience, expiry and the
+// required scopes of raw. The type must be "at+jwt" or "JWT", so ID tokens
+// and other JWTs of the issuer without one are rejected.
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Claims, error) {
+	const op = "verifier.Verify"
+
+	var payload json.RawMessage
+	header, err := jwt.Verify(ctx, raw, v.keys, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the media type may come with its "application/" prefix, RFC 7515
+	typ := strings.TrimPrefix(strings.ToLower(header.Type), "application/")
+	if !slices.Contains(accessTokenTypes, typ) {
+		return nil, fmt.Errorf("%s: %q: %w", op, header.Type, ErrInvalidType)
+	}
+
+	claims := new(Claims)
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%s: failed to unmarshal claims: %w", op, err)
+	}
+	if err := json.Unmarshal(payload, &claims.Extra); err != nil {
+		return nil, fmt.Errorf("%s: failed to unmarshal claims: %w", op, err)
+	}
+
+	if err := claims.Validate(jwt.Expected{
+		Issuer:   v.opts.Issuer,
+		Audience: v.opts.Audience,
+		Leeway:   v.opts.Leeway,
+	}); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !claims.HasScopes(v.opts.RequiredScopes...) {
+		return nil, fmt.Errorf("%s: %w", op, ErrMissingScope)
+	}
+
+	return claims, nil
+}
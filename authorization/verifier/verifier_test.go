@@ -0,0 +1,246 @@
+package verifier_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt"
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt/jwttest"
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/verifier"
+)
+
+type accessClaims struct {
+	jwt.Claims
+	Scope  string   `json:"scope,omitempty"`
+	Scp    []string `json:"scp,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+func TestVerify(t *testing.T) {
+	key, err := jwttest.NewKey("k1", "ES256")
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	issuer := jwttest.NewIssuer(key)
+	defer issuer.Close()
+
+	v, err := verifier.New(&verifier.Options{
+		Issuer:         issuer.URL(),
+		JWKSURL:        issuer.JWKSURL(),
+		Audience:       "api",
+		RequiredScopes: []string{"pcs:read"},
+		Leeway:         time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	claims := func(edit func(*accessClaims)) accessClaims {
+		c := accessClaims{
+			Claims: jwt.Claims{
+				Issuer:   issuer.URL(),
+				Subject:  "u1",
+				Audience: jwt.Audience{"api"},
+				Expiry:   time.Now().Add(time.Hour).Unix(),
+				IssuedAt: time.Now().Unix(),
+			},
+			Scope:  "pcs:read pcs:write",
+			Tenant: "t1",
+		}
+		if edit != nil {
+			edit(&c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims accessClaims
+		want   error
+	}{
+		{"valid", claims(nil), nil},
+		{"scp array", claims(func(c *accessClaims) {
+			c.Scope = ""
+			c.Scp = []string{"pcs:read"}
+		}), nil},
+		{"expired within leeway", claims(func(c *accessClaims) { c.Expiry = time.Now().Add(-30 * time.Second).Unix() }), nil},
+		{"expired", claims(func(c *accessClaims) { c.Expiry = time.Now().Add(-time.Hour).Unix() }), jwt.ErrExpired},
+		{"not yet valid", claims(func(c *accessClaims) { c.NotBefore = time.Now().Add(time.Hour).Unix() }), jwt.ErrNotYetValid},
+		{"issued in the future", claims(func(c *accessClaims) { c.IssuedAt = time.Now().Add(time.Hour).Unix() }), jwt.ErrIssuedInFuture},
+		{"issuer mismatch", claims(func(c *accessClaims) { c.Issuer = "https://evil" }), jwt.ErrInvalidIssuer},
+		{"audience mismatch", claims(func(c *accessClaims) { c.Audience = jwt.Audience{"other"} }), jwt.ErrInvalidAudience},
+		{"missing scope", claims(func(c *accessClaims) { c.Scope = "pcs:write" }), verifier.ErrMissingScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := key.Sign(tt.claims)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			got, err := v.Verify(t.Context(), raw)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Verify error = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if got.Subject != "u1" || got.Extra["tenant"] != "t1" || !got.HasScopes("pcs:read") {
+				t.Errorf("claims = %+v, want u1 of tenant t1 with pcs:read", got)
+			}
+		})
+	}
+}
+
+func TestVerifyForeignKey(t *testing.T) {
+	key, err := jwttest.NewKey("k1", "RS256")
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	foreign, err := jwttest.NewKey("k1", "RS256")
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	issuer := jwttest.NewIssuer(key)
+	defer issuer.Close()
+
+	v, err := verifier.New(&verifier.Options{
+		Issuer:            issuer.URL(),
+		JWKSURL:           issuer.JWKSURL(),
+		SkipAudienceCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	raw, err := foreign.Sign(jwt.Claims{Issuer: issuer.URL(), Expiry: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := v.Verify(t.Context(), raw); !errors.Is(err, jwt.ErrInvalidSignature) {
+		t.Errorf("Verify error = %v, want %v", err, jwt.ErrInvalidSignature)
+	}
+}
+
+func TestVerifyType(t *testing.T) {
+	key, err := jwttest.NewKey("k1", "ES256")
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	issuer := jwttest.NewIssuer(key)
+	defer issuer.Close()
+
+	v, err := verifier.New(&verifier.Options{Issuer: issuer.URL(), JWKSURL: issuer.JWKSURL(), Audience: "api"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	claims := jwt.Claims{
+		Issuer:   issuer.URL(),
+		Audience: jwt.Audience{"api"},
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+	}
+
+	tests := []struct {
+		typ string
+		ok  bool
+	}{
+		{"at+jwt", true},
+		{"application/at+jwt", true},
+		{"AT+JWT", true},
+		{"JWT", true},
+		{"", false},
+		{"id+jwt", false},
+		{"logout+jwt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			raw, err := key.SignWithType(tt.typ, claims)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			_, err = v.Verify(t.Context(), raw)
+			if tt.ok && err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, verifier.ErrInvalidType) {
+				t.Errorf("Verify error = %v, want %v", err, verifier.ErrInvalidType)
+			}
+		})
+	}
+}
+
+func TestNewAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience string
+		skip     bool
+		ok       bool
+	}{
+		{"audience", "api", false, true},
+		{"skipped", "", true, true},
+		{"missing", "", false, false},
+		{"both", "api", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.New(&verifier.Options{
+				Issuer:            "https://issuer",
+				JWKSURL:           "https://issuer/jwks",
+				Audience:          tt.audience,
+				SkipAudienceCheck: tt.skip,
+			})
+			if (err == nil) != tt.ok {
+				t.Errorf("New error = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestVerifySkipAudienceCheck(t *testing.T) {
+	key, err := jwttest.NewKey("k1", "ES256")
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	issuer := jwttest.NewIssuer(key)
+	defer issuer.Close()
+
+	v, err := verifier.New(&verifier.Options{
+		Issuer:            issuer.URL(),
+		JWKSURL:           issuer.JWKSURL(),
+		SkipAudienceCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	raw, err := key.Sign(jwt.Claims{
+		Issuer:   issuer.URL(),
+		Audience: jwt.Audience{"other"},
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := v.Verify(t.Context(), raw); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestClaimsScopes(t *testing.T) {
+	c := verifier.Claims{Scope: "a b", Scp: []string{"b", "c"}}
+
+	if got := c.Scopes(); len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("Scopes = %v, want [a b c]", got)
+	}
+	if !c.HasScopes("a", "c") || c.HasScopes("d") {
+		t.Error("HasScopes mismatch")
+	}
+}
@@ -49,6 +49,9 @@ func (e *Executor) StartListen(ctx context.Context, opts *StartListenOptions) er
 	}
 
 	e.commandTopic = opts.CommandTopic
+	if opts.ShareGroup != "" {
+		e.commandTopic = mqttAuth.SharedTopic(opts.ShareGroup, opts.CommandTopic)
+	}
 
 	if _, err := e.connection.Subscribe(ctx, &paho.Subscribe{
 		Subscriptions: []paho.SubscribeOptions{
@@ -68,13 +71,13 @@ func (e *Executor) StartListen(ctx context.Context, opts *StartListenOptions) er
 		}
 	}
 
-	e.router.RegisterHandler(e.commandTopic, e.messageHandler(
+	e.router.Handle(e.commandTopic, e.messageHandler(
 		ctx,
 		opts.Log,
 		opts.CommandMessageType,
 		topicFunc,
 		opts.LogMessageType,
-	))
+	), nil)
 
 	return nil
 }
@@ -96,16 +99,23 @@ func (e *Executor) StopListen(ctx context.Context) error {
 	return nil
 }
 
+// messageHandler returns the command handler. Commands are canceled when
+// either the connection or listenCtx is done.
 func (e *Executor) messageHandler(
-	ctx context.Context,
+	listenCtx context.Context,
 	log *slog.Logger,
 	messageType string,
 	logTopicFunc func(msg *commandMessage.Message) string,
 	logMessageType string,
-) paho.MessageHandler {
-	return func(publish *paho.Publish) {
+) mqttAuth.HandlerFunc {
+	return func(ctx context.Context, publish *paho.Publish) error {
 		const op = "commands.executor.messageHandler"
 
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(listenCtx, cancel)
+		defer stop()
+
 		log := log.With(sl.Op(op), sl.MsgID(publish))
 		log.Debug("received message")
 
@@ -113,13 +123,12 @@ func (e *Executor) messageHandler(
 
 		msg := new(commandMessage.Message)
 		if err := json.Unmarshal(publish.Payload, msg); err != nil {
-			log.Error("failed to unmarshal payload", sl.Err(err))
-			return
+			return fmt.Errorf("%s: failed to unmarshal payload: %w", op, err)
 		}
 
 		if msg.Type != messageType {
 			log.Debug("invalid message type, skipping")
-			return
+			return nil
 		}
 
 		log.Info(
@@ -127,15 +136,35 @@ func (e *Executor) messageHandler(
 			slog.String("command", msg.Data.Command),
 		)
 
+		msg.Publish = publish
+
+		if msg.Data.Expired(receivedAt) {
+			log.Info("command expired, skipping", slog.Time("expires_at", *msg.Data.ExpiresAt))
+
+			if err := mqttAuth.Ack(ctx); err != nil {
+				log.Warn("failed to ack command", sl.Err(err))
+			}
+
+			logMessage := NewLogMessage(msg.Data.Command, logMessageType, receivedAt, time.Now())
+			if err := e.sendLog(ctx, logTopicFunc(msg), logMessage.Expired()); err != nil {
+				log.Warn("failed to send expired log", sl.Err(err))
+			}
+			return nil
+		}
+
 		handler := e.getCommand(msg.Data.Command)
 		if handler == nil {
 			log.Warn("handler not found, skipping")
-			return
+			return nil
 		}
 
-		msg.Publish = publish
 		err := handler(ctx, msg)
 
+		// the command is done, so a redelivery would execute it twice
+		if err := mqttAuth.Ack(ctx); err != nil {
+			log.Warn("failed to ack command", sl.Err(err))
+		}
+
 		completedAt := time.Now()
 		logMessage := NewLogMessage(msg.Data.Command, logMessageType, receivedAt, completedAt)
 		logTopic := logTopicFunc(msg)
@@ -143,9 +172,8 @@ func (e *Executor) messageHandler(
 		if err == nil {
 			if err := e.sendLog(ctx, logTopic, logMessage.OK()); err != nil {
 				log.Warn("failed to send done log", sl.Err(err))
-				return
 			}
-			return
+			return nil
 		}
 
 		if commandErr, ok := errors.AsType[*CommandError](err); ok {
@@ -154,13 +182,13 @@ func (e *Executor) messageHandler(
 			if err := e.sendLog(ctx, logTopic, logMessage.CommandFailed(commandErr)); err != nil {
 				log.Warn("failed to send command error log", sl.Err(err))
 			}
-			return
+			return nil
 		}
 
-		log.Error("failed to handle message", sl.Err(err))
 		if err := e.sendLog(ctx, logTopic, logMessage.Internal()); err != nil {
 			log.Warn("failed to send internal error log", sl.Err(err))
 		}
+		return fmt.Errorf("%s: failed to handle command %q: %w", op, msg.Data.Command, err)
 	}
 }
 
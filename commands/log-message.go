@@ -8,6 +8,7 @@ const (
 	StatusOK            = "ok"
 	StatusCommandError  = "command-error"
 	StatusInternalError = "internal-error"
+	StatusExpired       = "expired"
 )
 
 type LogMessageData struct {
@@ -52,3 +53,8 @@ func (m *LogMessage) Internal() *LogMessage {
 	m.Data.Status = StatusInternalError
 	return m
 }
+
+func (m *LogMessage) Expired() *LogMessage {
+	m.Data.Status = StatusExpired
+	return m
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	commandMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/command-message"
+	mqttAuth "github.com/MaxRomanov007/smart-pc-go-lib/mqtt-auth"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type SendOptions struct {
+	Topic       string
+	MessageType string
+	Command     string
+	// Parameter is marshalled to json, nil sends no parameter.
+	Parameter any
+	// TTL sets both the MQTT message expiry and the expiresAt field, so the
+	// command is neither delivered nor executed after it. Zero never expires.
+	TTL time.Duration
+}
+
+func (o *SendOptions) check() error {
+	errs := make([]error, 0, 4)
+
+	if o.Topic == "" {
+		errs = append(errs, errors.New("topic required"))
+	}
+	if o.MessageType == "" {
+		errs = append(errs, errors.New("message type required"))
+	}
+	if o.Command == "" {
+		errs = append(errs, errors.New("command required"))
+	}
+	if o.TTL < 0 {
+		errs = append(errs, errors.New("ttl must not be negative"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Send publishes a command for an Executor.
+func Send(ctx context.Context, connection *mqttAuth.Connection, opts *SendOptions) error {
+	const op = "commands.sender.Send"
+
+	if err := opts.check(); err != nil {
+		return fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	msg := commandMessage.Message{
+		Type: opts.MessageType,
+		Data: commandMessage.Data{Command: opts.Command},
+	}
+
+	if opts.Parameter != nil {
+		parameter, err := json.Marshal(opts.Parameter)
+		if err != nil {
+			return fmt.Errorf("%s: failed to marshal parameter: %w", op, err)
+		}
+		msg.Data.Parameter = parameter
+	}
+
+	publish := &paho.Publish{Topic: opts.Topic, QoS: 1}
+
+	if opts.TTL > 0 {
+		expiresAt := time.Now().Add(opts.TTL)
+		msg.Data.ExpiresAt = &expiresAt
+
+		// the broker counts in whole seconds, round up to not drop early
+		expiry := uint32((opts.TTL + time.Second - 1) / time.Second)
+		publish.Properties = &paho.PublishProperties{MessageExpiry: &expiry}
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal json: %w", op, err)
+	}
+	publish.Payload = payload
+
+	if _, err := connection.Publish(ctx, publish); err != nil {
+		return fmt.Errorf("%s: failed to publish message: %w", op, err)
+	}
+
+	return nil
+}
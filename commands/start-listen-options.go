@@ -14,6 +14,9 @@ type StartListenOptions struct {
 	LogTopicFunc       func(msg *commandMessage.Message) string
 	LogMessageType     string
 	Log                *slog.Logger
+	// ShareGroup, when set, subscribes to the commands with a shared
+	// subscription, so replicas in the same group each get a part of them.
+	ShareGroup string
 }
 
 func (o *StartListenOptions) check() error {
@@ -3,6 +3,7 @@ package commandMessage
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	mqttMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/mqtt-message"
 )
@@ -10,6 +11,13 @@ import (
 type Data struct {
 	Command   string          `json:"command"`
 	Parameter json.RawMessage `json:"parameter"`
+	// ExpiresAt, when set, is the moment after which the command must not
+	// be executed anymore.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (d *Data) Expired(now time.Time) bool {
+	return d.ExpiresAt != nil && now.After(*d.ExpiresAt)
 }
 
 type Message mqttMessage.Message[Data]
@@ -0,0 +1,23 @@
+package presenceMessage
+
+import (
+	"time"
+
+	mqttMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/mqtt-message"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+type Data struct {
+	Status    string    `json:"status"`
+	Version   string    `json:"version,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	Uptime    int64     `json:"uptime"`
+	SentAt    time.Time `json:"sentAt"`
+}
+
+type Message mqttMessage.Message[Data]
@@ -1,5 +1,7 @@
 package user
 
+import "encoding/json"
+
 type Info struct {
 	AuthTime int        `json:"auth_time"`
 	IAT      int        `json:"iat"`
@@ -8,13 +10,52 @@ type Info struct {
 	Traits   InfoTraits `json:"traits"`
 }
 
+// InfoTraits are the common traits, the identity schema may define more,
+// see Decode.
 type InfoTraits struct {
 	Email   string         `json:"email"`
 	Name    InfoTraitsName `json:"name"`
 	Picture string         `json:"picture"`
+
+	raw json.RawMessage
 }
 
 type InfoTraitsName struct {
 	First string `json:"first"`
 	Last  string `json:"last"`
 }
+
+func (t *InfoTraits) UnmarshalJSON(data []byte) error {
+	type traits InfoTraits
+	if err := json.Unmarshal(data, (*traits)(t)); err != nil {
+		return err
+	}
+	t.raw = append(json.RawMessage(nil), data...)
+
+	return nil
+}
+
+// Decode unmarshals all traits into v, a struct with the custom traits of
+// the identity schema.
+func (t InfoTraits) Decode(v any) error {
+	if t.raw == nil {
+		return nil
+	}
+
+	return json.Unmarshal(t.raw, v)
+}
+
+// Trait unmarshals the trait name into v and reports whether it is set.
+func (t InfoTraits) Trait(name string, v any) (bool, error) {
+	var traits map[string]json.RawMessage
+	if err := t.Decode(&traits); err != nil {
+		return false, err
+	}
+
+	value, ok := traits[name]
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(value, v)
+}
@@ -0,0 +1,120 @@
+package authmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/api/response"
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/verifier"
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/go-chi/render"
+)
+
+type ctxKey string
+
+const claimsKey ctxKey = "claims"
+
+// New verifies the bearer token of every request and puts its claims into
+// the request context. scopes are required on top of the ones the verifier
+// requires, e.g. per route group.
+func New(
+	log *slog.Logger,
+	v *verifier.Verifier,
+	scopes ...string,
+) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const component = "middleware/authmw"
+			log := log.With(sl.Component(component), sl.ReqID(r))
+
+			token, ok := bearerToken(r)
+			if !ok {
+				log.Warn("bearer token is missing")
+				unauthorized(w, r, `Bearer`, "bearer token is missing")
+				return
+			}
+
+			claims, err := v.Verify(r.Context(), token)
+			switch {
+			case errors.Is(err, verifier.ErrMissingScope):
+				log.Warn("token lacks required scopes", sl.Err(err))
+				forbidden(w, r)
+				return
+			case err != nil:
+				log.Warn("invalid token", sl.Err(err))
+				unauthorized(w, r, `Bearer error="invalid_token"`, "invalid token")
+				return
+			}
+
+			if !claims.HasScopes(scopes...) {
+				log.Warn(
+					"token lacks required scopes",
+					slog.String("sub", claims.Subject),
+					slog.Any("scopes", scopes),
+				)
+				forbidden(w, r)
+				return
+			}
+
+			log.Debug("token verified", slog.String("sub", claims.Subject))
+
+			ctx := context.WithValue(r.Context(), claimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func FromContext(ctx context.Context) (*verifier.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*verifier.Claims)
+	return claims, ok
+}
+
+func MustFromContext(ctx context.Context) *verifier.Claims {
+	const op = "middlewares.authmw.MustFromContext"
+
+	claims, ok := FromContext(ctx)
+	if !ok {
+		panic(
+			fmt.Errorf(
+				"%s: can not get claims from context, looks like you forgot to use middleware",
+				op,
+			),
+		)
+	}
+	return claims
+}
+
+// Subject returns the verified subject, empty without the middleware.
+func Subject(ctx context.Context) string {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return claims.Subject
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, challenge, msg string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	render.Status(r, http.StatusUnauthorized)
+	render.JSON(w, r, response.Unauthorized(msg))
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+	render.Status(r, http.StatusForbidden)
+	render.JSON(w, r, response.Forbidden("insufficient scope"))
+}
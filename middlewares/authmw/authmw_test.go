@@ -0,0 +1,103 @@
+package authmw_test
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt"
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/jwt/jwttest"
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization/verifier"
+	"github.com/MaxRomanov007/smart-pc-go-lib/middlewares/authmw"
+)
+
+type accessClaims struct {
+	jwt.Claims
+	Scope string `json:"scope"`
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	key, err := jwttest.NewKey("k1", "RS256")
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	issuer := jwttest.NewIssuer(key)
+	defer issuer.Close()
+
+	v, err := verifier.New(&verifier.Options{
+		Issuer:            issuer.URL(),
+		JWKSURL:           issuer.JWKSURL(),
+		SkipAudienceCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	sign := func(scope string, expiry time.Time) string {
+		raw, err := key.Sign(accessClaims{
+			Claims: jwt.Claims{Issuer: issuer.URL(), Subject: "u1", Expiry: expiry.Unix()},
+			Scope:  scope,
+		})
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return raw
+	}
+
+	handler := authmw.New(slog.New(slog.DiscardHandler), v, "pcs:write")(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(authmw.Subject(r.Context())))
+		}),
+	)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+		body          string
+	}{
+		{"valid", "Bearer " + sign("pcs:write", time.Now().Add(time.Hour)), http.StatusOK, "", "u1"},
+		{"lowercase scheme", "bearer " + sign("pcs:write", time.Now().Add(time.Hour)), http.StatusOK, "", "u1"},
+		{"missing", "", http.StatusUnauthorized, `Bearer`, ""},
+		{"other scheme", "Basic dTE6cGFzcw==", http.StatusUnauthorized, `Bearer`, ""},
+		{"empty token", "Bearer ", http.StatusUnauthorized, `Bearer`, ""},
+		{"malformed", "Bearer abc", http.StatusUnauthorized, `Bearer error="invalid_token"`, ""},
+		{"expired", "Bearer " + sign("pcs:write", time.Now().Add(-time.Hour)), http.StatusUnauthorized, `Bearer error="invalid_token"`, ""},
+		{"missing scope", "Bearer " + sign("pcs:read", time.Now().Add(time.Hour)), http.StatusForbidden, `Bearer error="insufficient_scope"`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.challenge)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestMustFromContextPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustFromContext did not panic without the middleware")
+		}
+	}()
+
+	authmw.MustFromContext(t.Context())
+}
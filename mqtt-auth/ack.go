@@ -0,0 +1,95 @@
+package mqttAuth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type ackCtxKey struct{}
+
+// acker acknowledges a single received message at most once.
+type acker struct {
+	client  *paho.Client
+	publish *paho.Publish
+	enabled bool
+
+	mu       sync.Mutex
+	acked    bool
+	deferred bool
+}
+
+func newAcker(client *paho.Client, publish *paho.Publish, enabled bool) *acker {
+	return &acker{client: client, publish: publish, enabled: enabled}
+}
+
+func (a *acker) ack() error {
+	const op = "mqtt-auth.ack.ack"
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled || a.acked {
+		return nil
+	}
+	a.acked = true
+
+	if err := a.client.Ack(a.publish); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *acker) setDeferred() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.deferred = true
+}
+
+// ackIfNotDeferred is called by the router once every handler returned.
+func (a *acker) ackIfNotDeferred() error {
+	a.mu.Lock()
+	deferred := a.deferred
+	a.mu.Unlock()
+
+	if deferred {
+		return nil
+	}
+
+	return a.ack()
+}
+
+func withAcker(ctx context.Context, a *acker) context.Context {
+	return context.WithValue(ctx, ackCtxKey{}, a)
+}
+
+// Ack acknowledges the message being handled. It is a no-op when manual
+// acknowledgement is disabled or the message has already been acknowledged.
+// Without an explicit call the message is acknowledged after all handlers
+// return.
+func Ack(ctx context.Context) error {
+	a, ok := ctx.Value(ackCtxKey{}).(*acker)
+	if !ok {
+		return nil
+	}
+
+	return a.ack()
+}
+
+// DeferAck takes over acknowledgement of the message being handled, e.g. to
+// ack it only once a queued command has completed. The returned function must
+// eventually be called: the broker receives acknowledgements in order, so a
+// message that is never acked holds back the ones received after it.
+func DeferAck(ctx context.Context) func() error {
+	a, ok := ctx.Value(ackCtxKey{}).(*acker)
+	if !ok {
+		return func() error { return nil }
+	}
+
+	a.setDeferred()
+	return a.ack
+}
@@ -0,0 +1,409 @@
+package mqttAuth
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	// BatchProperty is the user property flagging a batch envelope, its value
+	// is the compressor name.
+	BatchProperty = "smart-pc-batch"
+
+	defaultBatchWindow      = time.Second
+	defaultBatchMaxMessages = 100
+	defaultBatchMaxBytes    = 64 * 1024
+	// defaultMaxDecompressedSize bounds received batches, far above what
+	// a batch of defaultBatchMaxBytes compresses from.
+	defaultMaxDecompressedSize = 16 * 1024 * 1024
+)
+
+var (
+	ErrUnknownCompressor = errors.New("unknown compressor")
+	ErrBatchTooLarge     = errors.New("batch too large")
+)
+
+// Compressor compresses batch envelopes. Only gzip is built in, zstd and
+// the like are deliberately left out to keep the dependencies small: add
+// them with RegisterCompressor on both sides. Decompress receives data from
+// any publisher on the topic, so it must bound its output.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		GzipCompressor{}.Name(): GzipCompressor{},
+	}
+)
+
+// RegisterCompressor makes c available for unbatching by its name.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	compressors[c.Name()] = c
+}
+
+func compressorByName(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// GzipCompressor is the default compressor. Register one with another
+// MaxSize to change the limit for received batches.
+type GzipCompressor struct {
+	// MaxSize is the largest decompressed batch accepted, larger ones fail
+	// with ErrBatchTooLarge. Defaults to 16MiB.
+	MaxSize int
+}
+
+func (GzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	limit := c.MaxSize
+	if limit <= 0 {
+		limit = defaultMaxDecompressedSize
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// one byte more than allowed tells a too large batch from a full one
+	decompressed, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > limit {
+		return nil, fmt.Errorf("more than %d bytes: %w", limit, ErrBatchTooLarge)
+	}
+
+	return decompressed, nil
+}
+
+type BatchOptions struct {
+	// Window is how long messages to the same topic are collected before
+	// being sent together. Defaults to 1s.
+	Window time.Duration
+	// MaxMessages and MaxBytes flush a batch early. Default to 100 messages
+	// and 64KiB of payload.
+	MaxMessages int
+	MaxBytes    int
+	// Compressor defaults to gzip.
+	Compressor Compressor
+	Log        *slog.Logger
+}
+
+func (o *BatchOptions) check() error {
+	errs := make([]error, 0, 1)
+
+	if o.Log == nil {
+		errs = append(errs, errors.New("log required"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Batcher coalesces messages published to the same topic within a window
+// into a single compressed envelope, which a Router on the other side splits
+// back into the original messages.
+//
+// Only the payload survives batching: retained messages and messages with
+// properties are published directly. A batch is published with the highest
+// QoS of its messages, and acking any of the messages on the receiving side
+// acks the whole batch.
+type Batcher struct {
+	opts       BatchOptions
+	connection *Connection
+	ctx        context.Context
+
+	mu      sync.Mutex
+	batches map[string]*batch
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+type batch struct {
+	topic    string
+	qos      byte
+	payloads [][]byte
+	size     int
+	timer    *time.Timer
+}
+
+// NewBatcher returns a batching layer over connection. Batches flushed by
+// the window are published with ctx.
+func NewBatcher(ctx context.Context, connection *Connection, opts *BatchOptions) (*Batcher, error) {
+	const op = "mqtt-auth.batching.NewBatcher"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	b := &Batcher{
+		opts:       *opts,
+		connection: connection,
+		ctx:        ctx,
+		batches:    make(map[string]*batch),
+	}
+	if b.opts.Window <= 0 {
+		b.opts.Window = defaultBatchWindow
+	}
+	if b.opts.MaxMessages <= 0 {
+		b.opts.MaxMessages = defaultBatchMaxMessages
+	}
+	if b.opts.MaxBytes <= 0 {
+		b.opts.MaxBytes = defaultBatchMaxBytes
+	}
+	if b.opts.Compressor == nil {
+		b.opts.Compressor = GzipCompressor{}
+	}
+
+	return b, nil
+}
+
+// Publish queues p for its topic's batch. Messages that can not be batched
+// are published right away.
+func (b *Batcher) Publish(ctx context.Context, p *paho.Publish) error {
+	const op = "mqtt-auth.batching.Publish"
+
+	if p.Retain || p.Properties != nil {
+		if _, err := b.connection.Publish(ctx, p); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return nil
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("%s: batcher closed", op)
+	}
+
+	bt, ok := b.batches[p.Topic]
+	if !ok {
+		bt = &batch{topic: p.Topic}
+		bt.timer = time.AfterFunc(b.opts.Window, func() {
+			b.flushTopic(bt)
+		})
+		b.batches[p.Topic] = bt
+	}
+	bt.qos = max(bt.qos, p.QoS)
+	bt.payloads = append(bt.payloads, p.Payload)
+	bt.size += len(p.Payload)
+
+	full := len(bt.payloads) >= b.opts.MaxMessages || bt.size >= b.opts.MaxBytes
+	if full {
+		b.detachDangerously(bt)
+	}
+	b.mu.Unlock()
+
+	if full {
+		if err := b.send(ctx, bt); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// Flush publishes every pending batch.
+func (b *Batcher) Flush(ctx context.Context) error {
+	const op = "mqtt-auth.batching.Flush"
+
+	b.mu.Lock()
+	pending := make([]*batch, 0, len(b.batches))
+	for _, bt := range b.batches {
+		b.detachDangerously(bt)
+		pending = append(pending, bt)
+	}
+	b.mu.Unlock()
+
+	var errs []error
+	for _, bt := range pending {
+		if err := b.send(ctx, bt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Close flushes the pending batches and rejects further messages.
+func (b *Batcher) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	err := b.Flush(ctx)
+	b.wg.Wait()
+
+	return err
+}
+
+func (b *Batcher) flushTopic(bt *batch) {
+	const op = "mqtt-auth.batching.flushTopic"
+
+	b.mu.Lock()
+	if b.batches[bt.topic] != bt {
+		// already sent by Flush or because it filled up
+		b.mu.Unlock()
+		return
+	}
+	b.detachDangerously(bt)
+	b.wg.Add(1)
+	b.mu.Unlock()
+	defer b.wg.Done()
+
+	if err := b.send(b.ctx, bt); err != nil {
+		b.opts.Log.Warn(
+			"failed to publish batch",
+			sl.Op(op),
+			slog.String("topic", bt.topic),
+			sl.Err(err),
+		)
+	}
+}
+
+func (b *Batcher) detachDangerously(bt *batch) {
+	bt.timer.Stop()
+	delete(b.batches, bt.topic)
+}
+
+func (b *Batcher) send(ctx context.Context, bt *batch) error {
+	p := &paho.Publish{Topic: bt.topic, QoS: bt.qos}
+
+	if len(bt.payloads) == 1 {
+		p.Payload = bt.payloads[0]
+	} else {
+		payload, err := b.opts.Compressor.Compress(encodeBatch(bt.payloads))
+		if err != nil {
+			return fmt.Errorf("failed to compress batch of %q: %w", bt.topic, err)
+		}
+
+		p.Payload = payload
+		p.Properties = &paho.PublishProperties{
+			User: paho.UserProperties{{
+				Key:   BatchProperty,
+				Value: b.opts.Compressor.Name(),
+			}},
+		}
+	}
+
+	if _, err := b.connection.Publish(ctx, p); err != nil {
+		return fmt.Errorf("failed to publish batch of %q: %w", bt.topic, err)
+	}
+
+	return nil
+}
+
+// encodeBatch frames each payload with its uvarint length.
+func encodeBatch(payloads [][]byte) []byte {
+	var buf []byte
+	for _, payload := range payloads {
+		buf = binary.AppendUvarint(buf, uint64(len(payload)))
+		buf = append(buf, payload...)
+	}
+
+	return buf
+}
+
+func decodeBatch(data []byte) ([][]byte, error) {
+	var payloads [][]byte
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, errors.New("malformed batch")
+		}
+
+		data = data[n:]
+		payloads = append(payloads, data[:size:size])
+		data = data[size:]
+	}
+
+	return payloads, nil
+}
+
+// unbatch splits a batch envelope into its messages. Other messages are
+// returned as is.
+func unbatch(p *paho.Publish) ([]*paho.Publish, error) {
+	const op = "mqtt-auth.batching.unbatch"
+
+	if p.Properties == nil {
+		return []*paho.Publish{p}, nil
+	}
+
+	name := p.Properties.User.Get(BatchProperty)
+	if name == "" {
+		return []*paho.Publish{p}, nil
+	}
+
+	compressor, ok := compressorByName(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: %q: %w", op, name, ErrUnknownCompressor)
+	}
+
+	data, err := compressor.Decompress(p.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to decompress batch: %w", op, err)
+	}
+
+	payloads, err := decodeBatch(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	messages := make([]*paho.Publish, 0, len(payloads))
+	for _, payload := range payloads {
+		msg := *p
+		msg.Payload = payload
+		msg.Properties = nil
+		messages = append(messages, &msg)
+	}
+
+	return messages, nil
+}
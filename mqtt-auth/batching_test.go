@@ -0,0 +1,103 @@
+package mqttAuth
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func batchEnvelope(t *testing.T, c Compressor, payloads ...[]byte) *paho.Publish {
+	t.Helper()
+
+	data, err := c.Compress(encodeBatch(payloads))
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+
+	return &paho.Publish{
+		Topic:   "users/u1/pcs/pc1/logs",
+		Payload: data,
+		Properties: &paho.PublishProperties{
+			User: paho.UserProperties{{Key: BatchProperty, Value: c.Name()}},
+		},
+	}
+}
+
+func TestUnbatch(t *testing.T) {
+	payloads := [][]byte{[]byte("a"), {}, []byte("ccc")}
+
+	messages, err := unbatch(batchEnvelope(t, GzipCompressor{}, payloads...))
+	if err != nil {
+		t.Fatalf("unbatch: %v", err)
+	}
+	if len(messages) != len(payloads) {
+		t.Fatalf("%d messages, want %d", len(messages), len(payloads))
+	}
+	for i, msg := range messages {
+		if !bytes.Equal(msg.Payload, payloads[i]) || msg.Properties != nil {
+			t.Errorf("message %d = %q with %+v, want %q", i, msg.Payload, msg.Properties, payloads[i])
+		}
+	}
+
+	plain := &paho.Publish{Topic: "t", Payload: []byte("x")}
+	messages, err = unbatch(plain)
+	if err != nil || len(messages) != 1 || messages[0] != plain {
+		t.Errorf("unbatch of a plain message = %v, %v", messages, err)
+	}
+}
+
+func TestUnbatchRejected(t *testing.T) {
+	unknown := batchEnvelope(t, GzipCompressor{}, []byte("a"), []byte("b"))
+	unknown.Properties.User[0].Value = "zstd"
+
+	malformed := batchEnvelope(t, GzipCompressor{}, []byte("a"))
+	data, err := GzipCompressor{}.Compress([]byte{0x05, 'a'})
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	malformed.Payload = data
+
+	// a few KiB of zeros expanding far beyond the limit
+	bomb := batchEnvelope(t, GzipCompressor{}, make([]byte, defaultMaxDecompressedSize))
+
+	tests := []struct {
+		name string
+		p    *paho.Publish
+		want error
+	}{
+		{"unknown compressor", unknown, ErrUnknownCompressor},
+		{"malformed", malformed, nil},
+		{"too large", bomb, ErrBatchTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := unbatch(tt.p)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("unbatch error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGzipCompressorMaxSize(t *testing.T) {
+	c := GzipCompressor{MaxSize: 8}
+
+	data, err := c.Compress([]byte("12345678"))
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	if got, err := c.Decompress(data); err != nil || string(got) != "12345678" {
+		t.Errorf("Decompress at the limit = %q, %v", got, err)
+	}
+
+	data, err = c.Compress([]byte("123456789"))
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	if _, err := c.Decompress(data); !errors.Is(err, ErrBatchTooLarge) {
+		t.Errorf("Decompress over the limit error = %v, want %v", err, ErrBatchTooLarge)
+	}
+}
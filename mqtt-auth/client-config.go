@@ -6,71 +6,193 @@ import (
 	"net/url"
 
 	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+	"github.com/MaxRomanov007/smart-pc-go-lib/domain/models/user"
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/paho"
 )
 
+// TokenProvider supplies the access token sent to the broker.
+// *authorization.Auth satisfies this interface automatically.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// UserTokenProvider is a TokenProvider of a single user, whose subject
+// scopes the topics. *authorization.Auth satisfies this interface.
+type UserTokenProvider interface {
+	TokenProvider
+	FetchUserInfo(ctx context.Context) (*user.Info, error)
+}
+
+// serviceIdentity is implemented by providers which may authenticate a
+// service instead of a user, such as *authorization.Auth.
+type serviceIdentity interface {
+	IsClientCredentials() bool
+	ClientID() string
+}
+
+var (
+	_ UserTokenProvider = (*authorization.Auth)(nil)
+	_ serviceIdentity   = (*authorization.Auth)(nil)
+)
+
 type ClientConfig struct {
 	autopaho.ClientConfig
 	topicFactory *TopicFactory
+	router       *Router
+	// service is set for connections shared by many users, whose requests
+	// are authorized per user rather than per connection.
+	service bool
 }
 
+// NewClientConfig builds the config of a connection authenticated as the
+// user of auth, usually an *authorization.Auth. The broker settings have to
+// be set on the embedded autopaho.ClientConfig, see
+// NewClientConfigWithOptions. A client credentials auth gives a service
+// config with the client id as username, see NewServiceClientConfig.
 func NewClientConfig(
 	ctx context.Context,
-	auth *authorization.Auth,
+	auth UserTokenProvider,
 ) (*ClientConfig, error) {
-	const op = "mqtt-auth.client-config.NewClientConfig"
+	return NewClientConfigWithOptions(ctx, auth, nil)
+}
+
+func NewClientConfigWithRouter(
+	ctx context.Context,
+	auth UserTokenProvider,
+) (*ClientConfig, *Router, error) {
+	return NewClientConfigWithOptionsAndRouter(ctx, auth, nil)
+}
+
+// NewClientConfigWithOptions is NewClientConfig with the broker settings
+// taken from opts, which may be nil.
+func NewClientConfigWithOptions(
+	ctx context.Context,
+	auth UserTokenProvider,
+	opts *Options,
+) (*ClientConfig, error) {
+	const op = "mqtt-auth.client-config.NewClientConfigWithOptions"
+
+	if service, ok := auth.(serviceIdentity); ok && service.IsClientCredentials() {
+		config, err := NewServiceClientConfig(ctx, auth, service.ClientID(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return config, nil
+	}
 
 	userinfo, err := auth.FetchUserInfo(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("%s: failed to fetch user info: %w", op, err)
 	}
 
+	tokenAuth := opts == nil || opts.passwordToken()
 	config := autopaho.ClientConfig{
-		ConnectPacketBuilder: connectPacketBuilder(ctx, auth, userinfo.Sub),
+		ConnectPacketBuilder: connectPacketBuilder(ctx, auth, userinfo.Sub, tokenAuth),
+	}
+
+	if opts != nil {
+		if err := opts.apply(ctx, &config, auth, userinfo.Sub); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
 	}
 
 	return &ClientConfig{ClientConfig: config, topicFactory: NewTopicFactory(userinfo.Sub)}, nil
 }
 
-func NewClientConfigWithRouter(
+func NewClientConfigWithOptionsAndRouter(
 	ctx context.Context,
-	auth *authorization.Auth,
+	auth UserTokenProvider,
+	opts *Options,
 ) (*ClientConfig, *Router, error) {
-	const op = "mqtt-auth.client-config.NewClientConfigWithRouter"
+	const op = "mqtt-auth.client-config.NewClientConfigWithOptionsAndRouter"
 
-	config, err := NewClientConfig(ctx, auth)
+	config, err := NewClientConfigWithOptions(ctx, auth, opts)
 	if err != nil {
 		return nil, nil, fmt.Errorf("%s: failed to create client config: %w", op, err)
 	}
 
-	router := NewRouter(config.topicFactory)
+	return config, config.newRouter(), nil
+}
 
-	config.ClientConfig.ClientConfig.OnPublishReceived = []func(paho.PublishReceived) (bool, error){
-		func(pr paho.PublishReceived) (bool, error) {
-			router.Route(pr.Packet.Packet())
-			return true, nil
-		},
+// NewServiceClientConfig builds the config of a backend connection which is
+// not bound to a single user: username identifies the service and topics are
+// used as is, so they have to be built with a user scoped TopicFactory.
+func NewServiceClientConfig(
+	ctx context.Context,
+	tokens TokenProvider,
+	username string,
+	opts *Options,
+) (*ClientConfig, error) {
+	const op = "mqtt-auth.client-config.NewServiceClientConfig"
+
+	tokenAuth := opts == nil || opts.passwordToken()
+	config := autopaho.ClientConfig{
+		ConnectPacketBuilder: connectPacketBuilder(ctx, tokens, username, tokenAuth),
 	}
 
-	return config, router, nil
+	if opts != nil {
+		if err := opts.apply(ctx, &config, tokens, username); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return &ClientConfig{
+		ClientConfig: config,
+		topicFactory: NewTopicFactory(""),
+		service:      true,
+	}, nil
+}
+
+// newRouter creates a router fed by the messages the connections created
+// with this config receive.
+func (c *ClientConfig) newRouter() *Router {
+	c.router = NewRouter(c.topicFactory)
+	return c.router
+}
+
+// onPublishReceived routes received messages with handler contexts derived
+// from ctx, which lives as long as the connection manager. manualAcks must
+// match the EnableManualAcknowledgment the client was created with, set by
+// Options.ManualAcks.
+func (c *ClientConfig) onPublishReceived(
+	ctx context.Context,
+	manualAcks bool,
+) func(paho.PublishReceived) (bool, error) {
+	return func(pr paho.PublishReceived) (bool, error) {
+		const op = "mqtt-auth.client-config.onPublishReceived"
+
+		a := newAcker(pr.Client, pr.Packet, manualAcks)
+		c.router.Route(withAcker(ctx, a), pr.Packet)
+
+		if err := a.ackIfNotDeferred(); err != nil {
+			return true, fmt.Errorf("%s: failed to ack message: %w", op, err)
+		}
+		return true, nil
+	}
 }
 
 func connectPacketBuilder(
 	ctx context.Context,
-	auth *authorization.Auth,
+	tokens TokenProvider,
 	username string,
+	tokenAuth bool,
 ) func(*paho.Connect, *url.URL) (*paho.Connect, error) {
 	return func(c *paho.Connect, u *url.URL) (*paho.Connect, error) {
 		const op = "commands.client-config.connectPacketBuilder"
 
-		token, err := auth.Token(ctx)
+		c.Username = username
+		c.UsernameFlag = true
+
+		if !tokenAuth {
+			return c, nil
+		}
+
+		token, err := tokens.Token(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("%s: failed to fetch token: %w", op, err)
 		}
 
-		c.Username = username
-		c.UsernameFlag = true
 		c.Password = []byte(token)
 		c.PasswordFlag = true
 
@@ -79,7 +201,7 @@ func connectPacketBuilder(
 }
 
 func (c *ClientConfig) SetWill(message *paho.WillMessage) {
-	message.Topic = c.topicFactory.UserTopic(message.Topic)
+	message.Topic = c.topicFactory.Resolve(message.Topic)
 
 	c.ClientConfig.WillMessage = message
 }
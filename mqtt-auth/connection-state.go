@@ -0,0 +1,184 @@
+package mqttAuth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+)
+
+type ConnectionState int
+
+const (
+	StateConnecting ConnectionState = iota
+	StateConnected
+	StateRenewing
+	StateDisconnected
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateRenewing:
+		return "renewing"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+type ConnectionEvent struct {
+	State    ConnectionState
+	Previous ConnectionState
+	// Err is the error that caused the transition, if any.
+	Err error
+	At  time.Time
+}
+
+// ConnectionHandler is called synchronously from the autopaho callbacks,
+// so it must not block.
+type ConnectionHandler func(ConnectionEvent)
+
+type ConnectionStatus struct {
+	State       ConnectionState
+	Since       time.Time
+	ConnectedAt time.Time
+	LastError   error
+	Reconnects  int
+	Renewals    int
+}
+
+type stateTracker struct {
+	mu       sync.Mutex
+	status   ConnectionStatus
+	manager  *autopaho.ConnectionManager
+	handlers map[int]ConnectionHandler
+	nextID   int
+	everUp   bool
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{
+		status:   ConnectionStatus{State: StateConnecting, Since: time.Now()},
+		handlers: make(map[int]ConnectionHandler),
+	}
+}
+
+func (t *stateTracker) snapshot() ConnectionStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status
+}
+
+func (t *stateTracker) subscribe(h ConnectionHandler) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.nextID
+	t.nextID++
+	t.handlers[id] = h
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		delete(t.handlers, id)
+	}
+}
+
+func (t *stateTracker) setManager(cm *autopaho.ConnectionManager) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.manager = cm
+}
+
+func (t *stateTracker) connecting() {
+	t.mu.Lock()
+	if t.status.State == StateRenewing || t.status.State == StateClosed {
+		t.mu.Unlock()
+		return
+	}
+	t.transitionDangerously(StateConnecting, nil)
+}
+
+func (t *stateTracker) renewing() {
+	t.mu.Lock()
+	t.status.Renewals++
+	t.transitionDangerously(StateRenewing, nil)
+}
+
+func (t *stateTracker) up() {
+	t.mu.Lock()
+	if t.everUp && t.status.State != StateRenewing {
+		t.status.Reconnects++
+	}
+	t.everUp = true
+	t.status.ConnectedAt = time.Now()
+	t.transitionDangerously(StateConnected, nil)
+}
+
+func (t *stateTracker) down() {
+	t.mu.Lock()
+	if t.status.State == StateRenewing {
+		t.mu.Unlock()
+		return
+	}
+	t.transitionDangerously(StateDisconnected, nil)
+}
+
+func (t *stateTracker) failed(err error) {
+	t.mu.Lock()
+	t.status.LastError = err
+	// a connected client reports the error before the connection goes down
+	if t.status.State == StateConnected {
+		t.mu.Unlock()
+		return
+	}
+	t.transitionDangerously(StateDisconnected, err)
+}
+
+func (t *stateTracker) managerDone(cm *autopaho.ConnectionManager) {
+	t.mu.Lock()
+	if t.manager != cm || t.status.State == StateRenewing {
+		t.mu.Unlock()
+		return
+	}
+	t.transitionDangerously(StateClosed, nil)
+}
+
+// transitionDangerously must be called with mu held; it releases mu before
+// calling the handlers.
+func (t *stateTracker) transitionDangerously(state ConnectionState, err error) {
+	event := ConnectionEvent{
+		State:    state,
+		Previous: t.status.State,
+		Err:      err,
+		At:       time.Now(),
+	}
+
+	t.status.State = state
+	t.status.Since = event.At
+
+	handlers := make([]ConnectionHandler, 0, len(t.handlers))
+	for _, h := range t.handlers {
+		handlers = append(handlers, h)
+	}
+	t.mu.Unlock()
+
+	if event.State == event.Previous {
+		return
+	}
+
+	for _, h := range handlers {
+		h(event)
+	}
+}
@@ -1,60 +1,202 @@
+// Package mqttAuth connects to the MQTT broker with the OAuth2 token of a
+// user or a service and scopes topics to the user, see TopicFactory.
 package mqttAuth
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sync"
 
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/packets"
 	"github.com/eclipse/paho.golang/paho"
 )
 
+// ErrNotAuthorized is returned when the broker denies a request and the
+// connection is not renewed before returning: on a service connection the
+// ACL of a single user denied it, so renewing the shared connection would
+// not help, and within a message handler the renew goes on in the
+// background, as it has to wait for the handler to return.
+var ErrNotAuthorized = errors.New("not authorized")
+
+var errConnectionClosed = errors.New("connection closed")
+
 type Connection struct {
-	*autopaho.ConnectionManager
 	topicFactory *TopicFactory
 	clientConfig *ClientConfig
+	// ctx bounds the lifetime of every connection manager, including
+	// the ones created by Renew.
+	ctx   context.Context
+	state *stateTracker
+
+	// managerMux guards the connection manager, which Renew replaces, the
+	// renew in flight and closed.
+	managerMux sync.Mutex
+	manager    *autopaho.ConnectionManager
+	renewal    *renewCall
+	closed     bool
+}
+
+// renewCall is a renew in flight, shared by everyone waiting for it.
+type renewCall struct {
+	done chan struct{}
+	err  error
 }
 
 func NewConnection(ctx context.Context, cfg *ClientConfig) (*Connection, error) {
 	const op = "mqtt-auth.connection.NewConnection"
 
-	connectionManager, err := newConnectionManager(ctx, cfg)
+	c := &Connection{
+		topicFactory: cfg.topicFactory,
+		clientConfig: cfg,
+		ctx:          ctx,
+		state:        newStateTracker(),
+	}
+
+	connectionManager, err := c.newConnectionManager()
 	if err != nil {
 		return nil, fmt.Errorf("%s: failed to create connection manager: %w", op, err)
 	}
+	if err := connectionManager.AwaitConnection(ctx); err != nil {
+		_ = connectionManager.Disconnect(context.Background())
+		return nil, fmt.Errorf("%s: failed to await connection: %w", op, err)
+	}
+
+	c.manager = connectionManager
+	return c, nil
+}
+
+// ConnectionManager returns the current connection manager. Renew replaces
+// it, so get it again instead of keeping it.
+func (c *Connection) ConnectionManager() *autopaho.ConnectionManager {
+	c.managerMux.Lock()
+	defer c.managerMux.Unlock()
+
+	return c.manager
+}
 
-	return &Connection{
-		ConnectionManager: connectionManager,
-		topicFactory:      cfg.topicFactory,
-		clientConfig:      cfg,
-	}, nil
+// AwaitConnection waits until the current connection manager is connected.
+func (c *Connection) AwaitConnection(ctx context.Context) error {
+	return c.ConnectionManager().AwaitConnection(ctx)
 }
 
-func newConnectionManager(
-	ctx context.Context,
-	cfg *ClientConfig,
-) (*autopaho.ConnectionManager, error) {
+// Disconnect closes the connection for good, a renew in flight gives up.
+func (c *Connection) Disconnect(ctx context.Context) error {
+	c.managerMux.Lock()
+	c.closed = true
+	manager := c.manager
+	c.managerMux.Unlock()
+
+	return manager.Disconnect(ctx)
+}
+
+// newConnectionManager starts a connection manager living as long as c.ctx,
+// not as the context of the caller.
+func (c *Connection) newConnectionManager() (*autopaho.ConnectionManager, error) {
 	const op = "mqtt-auth.connection.newConnectionManager"
 
-	connection, err := autopaho.NewConnection(ctx, cfg.ClientConfig)
+	c.state.connecting()
+
+	// handlers of the messages this manager receives get a context
+	// cancelled once it is done
+	msgCtx, cancel := context.WithCancel(c.ctx)
+
+	connection, err := autopaho.NewConnection(c.ctx, c.observedConfig(msgCtx))
 	if err != nil {
+		cancel()
+		c.state.failed(err)
 		return nil, fmt.Errorf("%s: failed to create connection: %w", op, err)
 	}
-	if err = connection.AwaitConnection(ctx); err != nil {
-		return nil, fmt.Errorf("%s: failed to await connection: %w", op, err)
-	}
+
+	c.state.setManager(connection)
+	go func() {
+		<-connection.Done()
+		cancel()
+		c.state.managerDone(connection)
+	}()
 
 	return connection, nil
 }
 
+// observedConfig returns a copy of the client config with the lifecycle
+// callbacks wrapped to feed the state tracker and the router, if any, fed
+// with msgCtx.
+func (c *Connection) observedConfig(msgCtx context.Context) autopaho.ClientConfig {
+	cfg := c.clientConfig.ClientConfig
+
+	if c.clientConfig.router != nil {
+		cfg.OnPublishReceived = append(
+			slices.Clone(cfg.OnPublishReceived),
+			c.clientConfig.onPublishReceived(msgCtx, cfg.EnableManualAcknowledgment),
+		)
+	}
+
+	onConnectionUp := cfg.OnConnectionUp
+	cfg.OnConnectionUp = func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+		c.state.up()
+		if onConnectionUp != nil {
+			onConnectionUp(cm, connack)
+		}
+	}
+
+	onConnectionDown := cfg.OnConnectionDown
+	cfg.OnConnectionDown = func() bool {
+		c.state.down()
+		if onConnectionDown != nil {
+			return onConnectionDown()
+		}
+		return true
+	}
+
+	onConnectError := cfg.OnConnectError
+	cfg.OnConnectError = func(err error) {
+		c.state.failed(err)
+		if onConnectError != nil {
+			onConnectError(err)
+		}
+	}
+
+	onClientError := cfg.OnClientError
+	cfg.OnClientError = func(err error) {
+		c.state.failed(err)
+		if onClientError != nil {
+			onClientError(err)
+		}
+	}
+
+	return cfg
+}
+
+// Status returns a snapshot of the connection state.
+func (c *Connection) Status() ConnectionStatus {
+	return c.state.snapshot()
+}
+
+// OnStateChange registers h for connection state transitions and returns
+// a function removing it.
+func (c *Connection) OnStateChange(h ConnectionHandler) func() {
+	return c.state.subscribe(h)
+}
+
 func (c *Connection) Subscribe(ctx context.Context, s *paho.Subscribe) (*paho.Suback, error) {
+	for i := 0; i < len(s.Subscriptions); i++ {
+		s.Subscriptions[i].Topic = c.topicFactory.Resolve(s.Subscriptions[i].Topic)
+	}
+
+	return c.subscribe(ctx, s)
+}
+
+// subscribe subscribes to the already resolved topics of s.
+func (c *Connection) subscribe(ctx context.Context, s *paho.Subscribe) (*paho.Suback, error) {
 	const op = "mqtt-auth.connection.Subscribe"
 
 	for i := 0; i < len(s.Subscriptions); i++ {
-		s.Subscriptions[i].Topic = c.topicFactory.UserTopic(s.Subscriptions[i].Topic)
+		s.Subscriptions[i].Topic = TopicFilter(s.Subscriptions[i].Topic)
 	}
 
-	ack, err := c.ConnectionManager.Subscribe(ctx, s)
+	ack, err := c.ConnectionManager().Subscribe(ctx, s)
 	if err == nil {
 		return ack, nil
 	}
@@ -64,11 +206,11 @@ func (c *Connection) Subscribe(ctx context.Context, s *paho.Subscribe) (*paho.Su
 		return ack, fmt.Errorf("%s: failed to subscribe: %w", op, err)
 	}
 
-	if err := c.Renew(ctx); err != nil {
-		return ack, fmt.Errorf("%s: failed to renew connection: %w", op, err)
+	if err := c.renewDenied(ctx, err); err != nil {
+		return ack, fmt.Errorf("%s: failed to subscribe: %w", op, err)
 	}
 
-	ack, err = c.ConnectionManager.Subscribe(ctx, s)
+	ack, err = c.ConnectionManager().Subscribe(ctx, s)
 	if err != nil {
 		return ack, fmt.Errorf("%s: failed to subscribe after renew: %w", op, err)
 	}
@@ -77,13 +219,22 @@ func (c *Connection) Subscribe(ctx context.Context, s *paho.Subscribe) (*paho.Su
 }
 
 func (c *Connection) Unsubscribe(ctx context.Context, u *paho.Unsubscribe) (*paho.Unsuback, error) {
+	for i := 0; i < len(u.Topics); i++ {
+		u.Topics[i] = c.topicFactory.Resolve(u.Topics[i])
+	}
+
+	return c.unsubscribe(ctx, u)
+}
+
+// unsubscribe unsubscribes from the already resolved topics of u.
+func (c *Connection) unsubscribe(ctx context.Context, u *paho.Unsubscribe) (*paho.Unsuback, error) {
 	const op = "mqtt-auth.connection.Unsubscribe"
 
 	for i := 0; i < len(u.Topics); i++ {
-		u.Topics[i] = c.topicFactory.UserTopic(u.Topics[i])
+		u.Topics[i] = TopicFilter(u.Topics[i])
 	}
 
-	ack, err := c.ConnectionManager.Unsubscribe(ctx, u)
+	ack, err := c.ConnectionManager().Unsubscribe(ctx, u)
 	if err == nil {
 		return ack, nil
 	}
@@ -93,11 +244,11 @@ func (c *Connection) Unsubscribe(ctx context.Context, u *paho.Unsubscribe) (*pah
 		return ack, fmt.Errorf("%s: failed to unsubscribe: %w", op, err)
 	}
 
-	if err := c.Renew(ctx); err != nil {
-		return ack, fmt.Errorf("%s: failed to renew connection: %w", op, err)
+	if err := c.renewDenied(ctx, err); err != nil {
+		return ack, fmt.Errorf("%s: failed to unsubscribe: %w", op, err)
 	}
 
-	ack, err = c.ConnectionManager.Unsubscribe(ctx, u)
+	ack, err = c.ConnectionManager().Unsubscribe(ctx, u)
 	if err != nil {
 		return ack, fmt.Errorf("%s: failed to unsubscribe after renew: %w", op, err)
 	}
@@ -106,11 +257,16 @@ func (c *Connection) Unsubscribe(ctx context.Context, u *paho.Unsubscribe) (*pah
 }
 
 func (c *Connection) Publish(ctx context.Context, p *paho.Publish) (*paho.PublishResponse, error) {
-	const op = "mqtt-auth.connection.Publish"
+	p.Topic = c.topicFactory.Resolve(p.Topic)
 
-	p.Topic = c.topicFactory.UserTopic(p.Topic)
+	return c.publish(ctx, p)
+}
 
-	ack, err := c.ConnectionManager.Publish(ctx, p)
+// publish publishes p on its already resolved topic.
+func (c *Connection) publish(ctx context.Context, p *paho.Publish) (*paho.PublishResponse, error) {
+	const op = "mqtt-auth.connection.Publish"
+
+	ack, err := c.ConnectionManager().Publish(ctx, p)
 	if err == nil {
 		return ack, nil
 	}
@@ -119,11 +275,11 @@ func (c *Connection) Publish(ctx context.Context, p *paho.Publish) (*paho.Publis
 		return ack, fmt.Errorf("%s: failed to publish: %w", op, err)
 	}
 
-	if err := c.Renew(ctx); err != nil {
-		return ack, fmt.Errorf("%s: failed to renew connection: %w", op, err)
+	if err := c.renewDenied(ctx, err); err != nil {
+		return ack, fmt.Errorf("%s: failed to publish: %w", op, err)
 	}
 
-	ack, err = c.ConnectionManager.Publish(ctx, p)
+	ack, err = c.ConnectionManager().Publish(ctx, p)
 	if err != nil {
 		return ack, fmt.Errorf("%s: failed to publish after renew: %w", op, err)
 	}
@@ -132,11 +288,16 @@ func (c *Connection) Publish(ctx context.Context, p *paho.Publish) (*paho.Publis
 }
 
 func (c *Connection) PublishViaQueue(ctx context.Context, p *autopaho.QueuePublish) error {
-	const op = "mqtt-auth.connection.PublishViaQueue"
+	p.Topic = c.topicFactory.Resolve(p.Topic)
+
+	return c.publishViaQueue(ctx, p)
+}
 
-	p.Topic = c.topicFactory.UserTopic(p.Topic)
+// publishViaQueue queues p for its already resolved topic.
+func (c *Connection) publishViaQueue(ctx context.Context, p *autopaho.QueuePublish) error {
+	const op = "mqtt-auth.connection.PublishViaQueue"
 
-	err := c.ConnectionManager.PublishViaQueue(ctx, p)
+	err := c.ConnectionManager().PublishViaQueue(ctx, p)
 	if err != nil {
 		return fmt.Errorf("%s: failed to publish: %w", op, err)
 	}
@@ -144,18 +305,120 @@ func (c *Connection) PublishViaQueue(ctx context.Context, p *autopaho.QueuePubli
 	return nil
 }
 
+// Renew reconnects with a fresh token, e.g. after the broker denied a
+// request the old one was not allowed to make. Concurrent callers share one
+// renew, each waiting until its own ctx is done.
 func (c *Connection) Renew(ctx context.Context) error {
 	const op = "mqtt-auth.connection.Renew"
 
-	if err := c.ConnectionManager.Disconnect(ctx); err != nil {
-		return fmt.Errorf("%s: failed to disconnect: %w", op, err)
+	c.managerMux.Lock()
+	call := c.renewDangerously()
+	c.managerMux.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	case <-call.done:
+	}
+	if call.err != nil {
+		return fmt.Errorf("%s: %w", op, call.err)
 	}
 
-	connectionManager, err := newConnectionManager(ctx, c.clientConfig)
+	if err := c.AwaitConnection(ctx); err != nil {
+		return fmt.Errorf("%s: failed to await connection: %w", op, err)
+	}
+
+	return nil
+}
+
+// renewDenied renews the connection after the broker denied a request with
+// err and returns nil when the request is worth retrying.
+func (c *Connection) renewDenied(ctx context.Context, err error) error {
+	if c.clientConfig.service {
+		return fmt.Errorf("%w: %w", ErrNotAuthorized, err)
+	}
+
+	// the renew disconnects the manager, which waits for the handler
+	// running on it to return
+	if _, ok := messageFromContext(ctx); ok {
+		c.managerMux.Lock()
+		c.renewDangerously()
+		c.managerMux.Unlock()
+
+		return fmt.Errorf("%w, renewing the connection: %w", ErrNotAuthorized, err)
+	}
+
+	if err := c.Renew(ctx); err != nil {
+		return fmt.Errorf("failed to renew connection: %w", err)
+	}
+
+	return nil
+}
+
+// renewDangerously returns the renew in flight or starts one. It must be
+// called with managerMux held.
+func (c *Connection) renewDangerously() *renewCall {
+	if c.renewal != nil {
+		return c.renewal
+	}
+
+	call := &renewCall{done: make(chan struct{})}
+	if c.closed {
+		call.err = errConnectionClosed
+		close(call.done)
+		return call
+	}
+
+	c.renewal = call
+	go c.runRenew(call, c.manager)
+
+	return call
+}
+
+// runRenew replaces old with a new connection manager. It is bound to the
+// connection rather than to a caller, so a caller giving up does not leave
+// the connection without a manager.
+func (c *Connection) runRenew(call *renewCall, old *autopaho.ConnectionManager) {
+	c.state.renewing()
+
+	call.err = c.replaceManager(old)
+	if call.err != nil {
+		c.state.failed(call.err)
+	}
+
+	c.managerMux.Lock()
+	c.renewal = nil
+	c.managerMux.Unlock()
+	close(call.done)
+}
+
+func (c *Connection) replaceManager(old *autopaho.ConnectionManager) error {
+	if err := old.Disconnect(c.ctx); err != nil {
+		return fmt.Errorf("failed to disconnect: %w", err)
+	}
+
+	connectionManager, err := c.newConnectionManager()
 	if err != nil {
-		return fmt.Errorf("%s: failed to create connection manager: %w", op, err)
+		return fmt.Errorf("failed to create connection manager: %w", err)
+	}
+
+	// keep the new manager even if it does not connect yet: it goes on
+	// reconnecting, unless the connection was closed meanwhile
+	c.managerMux.Lock()
+	closed := c.closed
+	if !closed {
+		c.manager = connectionManager
+	}
+	c.managerMux.Unlock()
+
+	if closed {
+		_ = connectionManager.Disconnect(c.ctx)
+		return errConnectionClosed
 	}
 
-	c.ConnectionManager = connectionManager
 	return nil
 }
+
+func (c *Connection) TopicFactory() *TopicFactory {
+	return c.topicFactory
+}
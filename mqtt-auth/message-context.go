@@ -0,0 +1,57 @@
+package mqttAuth
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type messageCtxKey struct{}
+
+type messageInfo struct {
+	id    uint16
+	topic string
+	log   *slog.Logger
+}
+
+func withMessage(ctx context.Context, p *paho.Publish, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, messageCtxKey{}, &messageInfo{
+		id:    p.PacketID,
+		topic: p.Topic,
+		log: log.With(
+			slog.String("topic", p.Topic),
+			slog.Uint64("message_id", uint64(p.PacketID)),
+		),
+	})
+}
+
+func messageFromContext(ctx context.Context) (*messageInfo, bool) {
+	info, ok := ctx.Value(messageCtxKey{}).(*messageInfo)
+	return info, ok
+}
+
+// MessageID returns the packet id of the message being handled.
+func MessageID(ctx context.Context) uint16 {
+	if info, ok := messageFromContext(ctx); ok {
+		return info.id
+	}
+	return 0
+}
+
+// MessageTopic returns the broker topic of the message being handled.
+func MessageTopic(ctx context.Context) string {
+	if info, ok := messageFromContext(ctx); ok {
+		return info.topic
+	}
+	return ""
+}
+
+// Logger returns the router logger enriched with the message topic and id.
+// It falls back to slog.Default outside of a handler.
+func Logger(ctx context.Context) *slog.Logger {
+	if info, ok := messageFromContext(ctx); ok {
+		return info.log
+	}
+	return slog.Default()
+}
@@ -0,0 +1,133 @@
+package mqtttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/authorization"
+	"github.com/MaxRomanov007/smart-pc-go-lib/domain/models/user"
+	"github.com/eclipse/paho.golang/packets"
+	"golang.org/x/oauth2"
+)
+
+const tokenLifetime = time.Hour
+
+// Auth is a fake OAuth2 provider serving the token and userinfo endpoints
+// for a single user, together with a ready *authorization.Auth logged in
+// as that user.
+type Auth struct {
+	*authorization.Auth
+	server *httptest.Server
+
+	mu     sync.Mutex
+	userID string
+	token  string
+	issued int
+}
+
+// NewAuth starts the fake provider and loads an Auth for userID.
+func NewAuth(ctx context.Context, userID string) (*Auth, error) {
+	const op = "mqtttest.auth.NewAuth"
+
+	a := &Auth{userID: userID}
+	a.token = a.nextTokenDangerously()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", a.handleToken)
+	mux.HandleFunc("/userinfo", a.handleUserInfo)
+	a.server = httptest.NewServer(mux)
+
+	cfg := &authorization.Config{
+		Oauth2Config: &oauth2.Config{
+			ClientID: "mqtttest",
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  a.server.URL + "/auth",
+				TokenURL: a.server.URL + "/token",
+			},
+		},
+		LoadToken: func(context.Context) (*oauth2.Token, error) {
+			return a.oauth2Token(), nil
+		},
+		UserInfoURL: a.server.URL + "/userinfo",
+	}
+
+	auth, err := authorization.Load(ctx, cfg)
+	if err != nil {
+		a.server.Close()
+		return nil, fmt.Errorf("%s: failed to load auth: %w", op, err)
+	}
+	a.Auth = auth
+
+	return a, nil
+}
+
+// AccessToken returns the token the provider issued last, e.g. to check it
+// in a broker Authenticator.
+func (a *Auth) AccessToken() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.token
+}
+
+// Authenticator accepts clients presenting the current access token as the
+// CONNECT password and rejects everything else with NotAuthorized.
+func (a *Auth) Authenticator() Authenticator {
+	return func(client ConnectInfo) byte {
+		if string(client.Password) != a.AccessToken() {
+			return packets.ConnackNotAuthorized
+		}
+		return packets.ConnackSuccess
+	}
+}
+
+func (a *Auth) Close() {
+	a.server.Close()
+}
+
+func (a *Auth) oauth2Token() *oauth2.Token {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return &oauth2.Token{
+		AccessToken:  a.token,
+		TokenType:    "Bearer",
+		RefreshToken: "refresh-" + a.userID,
+		Expiry:       time.Now().Add(tokenLifetime),
+	}
+}
+
+func (a *Auth) nextTokenDangerously() string {
+	a.issued++
+	return fmt.Sprintf("token-%s-%d", a.userID, a.issued)
+}
+
+func (a *Auth) handleToken(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.token = a.nextTokenDangerously()
+	token := a.token
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  token,
+		"token_type":    "Bearer",
+		"refresh_token": "refresh-" + a.userID,
+		"expires_in":    int(tokenLifetime.Seconds()),
+	})
+}
+
+func (a *Auth) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+a.AccessToken() {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(user.Info{Sub: a.userID})
+}
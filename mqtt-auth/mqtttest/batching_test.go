@@ -0,0 +1,153 @@
+package mqtttest_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	mqttAuth "github.com/MaxRomanov007/smart-pc-go-lib/mqtt-auth"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type batchEnv struct {
+	batcher *mqttAuth.Batcher
+	// sent are the messages as the broker delivers them, received the ones
+	// the router hands to the handlers.
+	sent     <-chan *paho.Publish
+	received <-chan *paho.Publish
+}
+
+func newBatchEnv(t *testing.T, opts mqttAuth.BatchOptions) *batchEnv {
+	t.Helper()
+
+	e := newEnv(t)
+	sent := make(chan *paho.Publish, 16)
+	connection, router := e.connect(t, "batcher", func(cfg *mqttAuth.ClientConfig) {
+		cfg.OnPublishReceived = append(cfg.OnPublishReceived, func(pr paho.PublishReceived) (bool, error) {
+			sent <- pr.Packet
+			return false, nil
+		})
+	})
+	received := subscribe(t, connection, router, logTopic)
+
+	opts.Log = slog.New(slog.DiscardHandler)
+	batcher, err := mqttAuth.NewBatcher(t.Context(), connection, &opts)
+	if err != nil {
+		t.Fatalf("NewBatcher: %v", err)
+	}
+
+	return &batchEnv{batcher: batcher, sent: sent, received: received}
+}
+
+func (e *batchEnv) publish(t *testing.T, payloads ...string) {
+	t.Helper()
+
+	for _, payload := range payloads {
+		if err := e.batcher.Publish(t.Context(), &paho.Publish{
+			Topic:   logTopic,
+			QoS:     1,
+			Payload: []byte(payload),
+		}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+// expectBatch checks that payloads arrived as one batch envelope.
+func (e *batchEnv) expectBatch(t *testing.T, payloads ...string) {
+	t.Helper()
+
+	envelope := receive(t, e.sent)
+	if envelope.Properties == nil || envelope.Properties.User.Get(mqttAuth.BatchProperty) != "gzip" {
+		t.Fatalf("sent %q with %+v, want a gzip batch", envelope.Payload, envelope.Properties)
+	}
+	for _, want := range payloads {
+		if p := receive(t, e.received); string(p.Payload) != want {
+			t.Errorf("received %q, want %q", p.Payload, want)
+		}
+	}
+
+	select {
+	case p := <-e.sent:
+		t.Errorf("sent %q besides the batch", p.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBatcherWindow(t *testing.T) {
+	e := newBatchEnv(t, mqttAuth.BatchOptions{Window: 100 * time.Millisecond})
+
+	e.publish(t, "a", "b", "c")
+	select {
+	case p := <-e.sent:
+		t.Fatalf("sent %q before the window ended", p.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	e.expectBatch(t, "a", "b", "c")
+}
+
+func TestBatcherFlushEarly(t *testing.T) {
+	tests := []struct {
+		name string
+		opts mqttAuth.BatchOptions
+	}{
+		{"max messages", mqttAuth.BatchOptions{Window: time.Hour, MaxMessages: 2}},
+		{"max bytes", mqttAuth.BatchOptions{Window: time.Hour, MaxBytes: 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newBatchEnv(t, tt.opts)
+
+			e.publish(t, "abc", "de")
+			e.expectBatch(t, "abc", "de")
+		})
+	}
+}
+
+func TestBatcherBypass(t *testing.T) {
+	tests := []struct {
+		name    string
+		publish paho.Publish
+	}{
+		{"retained", paho.Publish{Retain: true}},
+		{"properties", paho.Publish{Properties: &paho.PublishProperties{ContentType: "text/plain"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newBatchEnv(t, mqttAuth.BatchOptions{Window: time.Hour})
+
+			p := tt.publish
+			p.Topic, p.QoS, p.Payload = logTopic, 1, []byte("direct")
+			if err := e.batcher.Publish(t.Context(), &p); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			sent := receive(t, e.sent)
+			if string(sent.Payload) != "direct" ||
+				(sent.Properties != nil && sent.Properties.User.Get(mqttAuth.BatchProperty) != "") {
+				t.Errorf("sent %q with %+v, want the message as is", sent.Payload, sent.Properties)
+			}
+			if p.Properties != nil && (sent.Properties == nil || sent.Properties.ContentType != "text/plain") {
+				t.Errorf("sent properties %+v, want the content type kept", sent.Properties)
+			}
+		})
+	}
+}
+
+func TestBatcherClose(t *testing.T) {
+	e := newBatchEnv(t, mqttAuth.BatchOptions{Window: time.Hour})
+
+	e.publish(t, "a", "b")
+	if err := e.batcher.Close(t.Context()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	e.expectBatch(t, "a", "b")
+
+	if err := e.batcher.Publish(context.Background(), &paho.Publish{Topic: logTopic, Payload: []byte("late")}); err == nil {
+		t.Error("Publish after Close succeeded")
+	}
+}
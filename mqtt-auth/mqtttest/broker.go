@@ -0,0 +1,454 @@
+// Package mqtttest provides an in-process MQTT v5 broker and a fake
+// authorization server for end-to-end tests of code built on mqttAuth,
+// in the spirit of net/http/httptest.
+//
+// The broker is intentionally small: it keeps no session state between
+// connections, delivers at most QoS 1 and supports retained messages,
+// wills, wildcards, shared subscriptions and per-topic ACLs.
+package mqtttest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	mqttAuth "github.com/MaxRomanov007/smart-pc-go-lib/mqtt-auth"
+	"github.com/eclipse/paho.golang/packets"
+)
+
+type Action int
+
+const (
+	ActionPublish Action = iota
+	ActionSubscribe
+	ActionUnsubscribe
+)
+
+// ConnectInfo describes a client trying to connect.
+type ConnectInfo struct {
+	ClientID string
+	Username string
+	Password []byte
+}
+
+// ACL reports whether the client may perform action on topic. For
+// subscriptions topic is the requested filter.
+type ACL func(client ConnectInfo, action Action, topic string) bool
+
+// Authenticator returns a CONNACK reason code, packets.ConnackSuccess
+// accepts the client.
+type Authenticator func(client ConnectInfo) byte
+
+type BrokerOptions struct {
+	// Authenticate defaults to accepting every client.
+	Authenticate Authenticator
+	// ACL defaults to allowing everything.
+	ACL ACL
+}
+
+type Broker struct {
+	listener net.Listener
+
+	mu           sync.RWMutex
+	authenticate Authenticator
+	acl          ACL
+	clients      map[*client]struct{}
+	retained     map[string]*packets.Publish
+	acks         int
+	closed       bool
+
+	wg sync.WaitGroup
+}
+
+// NewBroker starts a broker listening on a random localhost port.
+// opts may be nil.
+func NewBroker(opts *BrokerOptions) (*Broker, error) {
+	const op = "mqtttest.broker.NewBroker"
+
+	if opts == nil {
+		opts = &BrokerOptions{}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to listen: %w", op, err)
+	}
+
+	b := &Broker{
+		listener:     listener,
+		authenticate: opts.Authenticate,
+		acl:          opts.ACL,
+		clients:      make(map[*client]struct{}),
+		retained:     make(map[string]*packets.Publish),
+	}
+
+	b.wg.Add(1)
+	go b.accept()
+
+	return b, nil
+}
+
+// URL returns the mqtt:// url of the broker.
+func (b *Broker) URL() *url.URL {
+	return &url.URL{Scheme: "mqtt", Host: b.listener.Addr().String()}
+}
+
+// SetACL replaces the ACL, e.g. to deny a topic until the client renews
+// its connection.
+func (b *Broker) SetACL(acl ACL) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.acl = acl
+}
+
+func (b *Broker) SetAuthenticator(authenticate Authenticator) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.authenticate = authenticate
+}
+
+// Publish delivers a message to the subscribers as if a client sent it.
+func (b *Broker) Publish(topic string, payload []byte, retain bool) {
+	pb := packets.NewControlPacket(packets.PUBLISH).Content.(*packets.Publish)
+	pb.Topic = topic
+	pb.Payload = payload
+	pb.Retain = retain
+	pb.QoS = 1
+
+	b.route(pb)
+}
+
+// Retained returns the retained payload of topic.
+func (b *Broker) Retained(topic string) ([]byte, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	pb, ok := b.retained[topic]
+	if !ok {
+		return nil, false
+	}
+
+	return pb.Payload, true
+}
+
+// Acks returns the number of PUBACKs received from clients, e.g. to check
+// when a client acknowledges a message with manual acks enabled.
+func (b *Broker) Acks() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.acks
+}
+
+// DropClients closes every client connection without a DISCONNECT,
+// so wills are published and clients reconnect.
+func (b *Broker) DropClients() {
+	b.mu.RLock()
+	clients := make([]*client, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.RUnlock()
+
+	for _, c := range clients {
+		_ = c.conn.Close()
+	}
+}
+
+func (b *Broker) Close() error {
+	const op = "mqtttest.broker.Close"
+
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	err := b.listener.Close()
+	b.DropClients()
+	b.wg.Wait()
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serve(conn)
+		}()
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	cp, err := packets.ReadPacket(conn)
+	if err != nil || cp.Type != packets.CONNECT {
+		return
+	}
+
+	c := newClient(conn, cp.Content.(*packets.Connect))
+	if !b.connect(c) {
+		return
+	}
+	defer b.disconnect(c)
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			// the connection dropped without a DISCONNECT
+			b.publishWill(c)
+			return
+		}
+
+		switch p := cp.Content.(type) {
+		case *packets.Publish:
+			b.handlePublish(c, p)
+		case *packets.Puback:
+			b.mu.Lock()
+			b.acks++
+			b.mu.Unlock()
+		case *packets.Pubrel:
+			_ = c.write(packets.PUBCOMP, func(content packets.Packet) {
+				content.(*packets.Pubcomp).PacketID = p.PacketID
+			})
+		case *packets.Subscribe:
+			b.handleSubscribe(c, p)
+		case *packets.Unsubscribe:
+			b.handleUnsubscribe(c, p)
+		case *packets.Pingreq:
+			_ = c.write(packets.PINGRESP, nil)
+		case *packets.Disconnect:
+			if p.ReasonCode == packets.DisconnectDisconnectWithWillMessage {
+				b.publishWill(c)
+			}
+			return
+		}
+	}
+}
+
+func (b *Broker) connect(c *client) bool {
+	b.mu.Lock()
+	authenticate := b.authenticate
+	closed := b.closed
+	if !closed {
+		b.clients[c] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	reason := byte(packets.ConnackSuccess)
+	if closed {
+		reason = packets.ConnackServerUnavailable
+	} else if authenticate != nil {
+		reason = authenticate(c.info)
+	}
+
+	available := byte(1)
+	_ = c.write(packets.CONNACK, func(content packets.Packet) {
+		connack := content.(*packets.Connack)
+		connack.ReasonCode = reason
+		connack.Properties.RetainAvailable = &available
+		connack.Properties.WildcardSubAvailable = &available
+		connack.Properties.SubIDAvailable = &available
+		connack.Properties.SharedSubAvailable = &available
+	})
+
+	if reason >= 0x80 {
+		b.disconnect(c)
+		return false
+	}
+
+	return true
+}
+
+func (b *Broker) disconnect(c *client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.clients, c)
+}
+
+func (b *Broker) allowed(c *client, action Action, topic string) bool {
+	b.mu.RLock()
+	acl := b.acl
+	b.mu.RUnlock()
+
+	return acl == nil || acl(c.info, action, topic)
+}
+
+func (b *Broker) handlePublish(c *client, p *packets.Publish) {
+	reason := byte(packets.PubackSuccess)
+	if !b.allowed(c, ActionPublish, p.Topic) {
+		reason = packets.PubackNotAuthorized
+	}
+
+	switch p.QoS {
+	case 1:
+		_ = c.write(packets.PUBACK, func(content packets.Packet) {
+			puback := content.(*packets.Puback)
+			puback.PacketID = p.PacketID
+			puback.ReasonCode = reason
+		})
+	case 2:
+		_ = c.write(packets.PUBREC, func(content packets.Packet) {
+			pubrec := content.(*packets.Pubrec)
+			pubrec.PacketID = p.PacketID
+			pubrec.ReasonCode = reason
+		})
+	}
+
+	if reason == packets.PubackSuccess {
+		b.route(p)
+	}
+}
+
+func (b *Broker) handleSubscribe(c *client, s *packets.Subscribe) {
+	reasons := make([]byte, 0, len(s.Subscriptions))
+	var granted []packets.SubOptions
+
+	for _, sub := range s.Subscriptions {
+		if !b.allowed(c, ActionSubscribe, sub.Topic) {
+			reasons = append(reasons, packets.SubackNotauthorized)
+			continue
+		}
+
+		sub.QoS = min(sub.QoS, 1)
+		c.subscribe(sub)
+		granted = append(granted, sub)
+		reasons = append(reasons, sub.QoS)
+	}
+
+	_ = c.write(packets.SUBACK, func(content packets.Packet) {
+		suback := content.(*packets.Suback)
+		suback.PacketID = s.PacketID
+		suback.Reasons = reasons
+	})
+
+	b.mu.RLock()
+	var retained []*packets.Publish
+	for _, sub := range granted {
+		// retained messages are not sent to shared subscriptions
+		if _, _, shared := mqttAuth.SplitSharedTopic(sub.Topic); shared || sub.RetainHandling == 2 {
+			continue
+		}
+		for topic, pb := range b.retained {
+			if matchTopic(sub.Topic, topic) {
+				retained = append(retained, pb)
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, pb := range retained {
+		c.deliver(pb, 1, true)
+	}
+}
+
+func (b *Broker) handleUnsubscribe(c *client, u *packets.Unsubscribe) {
+	reasons := make([]byte, 0, len(u.Topics))
+
+	for _, topic := range u.Topics {
+		switch {
+		case !b.allowed(c, ActionUnsubscribe, topic):
+			reasons = append(reasons, packets.UnsubackNotAuthorized)
+		case c.unsubscribe(topic):
+			reasons = append(reasons, packets.UnsubackSuccess)
+		default:
+			reasons = append(reasons, packets.UnsubackNoSubscriptionFound)
+		}
+	}
+
+	_ = c.write(packets.UNSUBACK, func(content packets.Packet) {
+		unsuback := content.(*packets.Unsuback)
+		unsuback.PacketID = u.PacketID
+		unsuback.Reasons = reasons
+	})
+}
+
+func (b *Broker) publishWill(c *client) {
+	if c.will == nil {
+		return
+	}
+
+	b.route(c.will)
+}
+
+func (b *Broker) route(p *packets.Publish) {
+	b.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.Topic)
+		} else {
+			b.retained[p.Topic] = p
+		}
+	}
+
+	clients := make([]*client, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+
+	type member struct {
+		client *client
+		qos    byte
+	}
+	shares := make(map[string][]member)
+
+	for _, c := range clients {
+		qos, ok, shared := c.matches(p.Topic)
+		if ok {
+			c.deliver(p, min(qos, p.QoS), false)
+		}
+		for filter, qos := range shared {
+			shares[filter] = append(shares[filter], member{client: c, qos: qos})
+		}
+	}
+
+	// every shared subscription gets the message once, on a random member
+	for _, members := range shares {
+		m := members[rand.IntN(len(members))]
+		m.client.deliver(p, min(m.qos, p.QoS), false)
+	}
+}
+
+// matchTopic reports whether topic matches the filter.
+func matchTopic(filter, topic string) bool {
+	filterSegments := strings.Split(filter, "/")
+	topicSegments := strings.Split(topic, "/")
+
+	if strings.HasPrefix(topic, "$") &&
+		(filterSegments[0] == "+" || filterSegments[0] == "#") {
+		return false
+	}
+
+	for i, segment := range filterSegments {
+		if segment == "#" {
+			return true
+		}
+		if i >= len(topicSegments) {
+			return false
+		}
+		if segment != "+" && segment != topicSegments[i] {
+			return false
+		}
+	}
+
+	return len(filterSegments) == len(topicSegments)
+}
@@ -0,0 +1,142 @@
+package mqtttest
+
+import (
+	"net"
+	"sync"
+
+	mqttAuth "github.com/MaxRomanov007/smart-pc-go-lib/mqtt-auth"
+	"github.com/eclipse/paho.golang/packets"
+)
+
+type client struct {
+	conn net.Conn
+	info ConnectInfo
+	will *packets.Publish
+
+	writeMu sync.Mutex
+	nextID  uint16
+
+	subsMu sync.RWMutex
+	subs   map[string]byte
+}
+
+func newClient(conn net.Conn, connect *packets.Connect) *client {
+	c := &client{
+		conn: conn,
+		info: ConnectInfo{
+			ClientID: connect.ClientID,
+			Username: connect.Username,
+			Password: connect.Password,
+		},
+		subs: make(map[string]byte),
+	}
+
+	if connect.WillFlag {
+		will := packets.NewControlPacket(packets.PUBLISH).Content.(*packets.Publish)
+		will.Topic = connect.WillTopic
+		will.Payload = connect.WillMessage
+		will.QoS = connect.WillQOS
+		will.Retain = connect.WillRetain
+		if connect.WillProperties != nil {
+			will.Properties = connect.WillProperties
+		}
+		c.will = will
+	}
+
+	return c
+}
+
+// write sends a packet of type t after letting fill populate its content.
+// fill runs under writeMu, so packet ids are sent in the order taken.
+func (c *client) write(t byte, fill func(packets.Packet)) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	cp := packets.NewControlPacket(t)
+	if fill != nil {
+		fill(cp.Content)
+	}
+
+	_, err := cp.WriteTo(c.conn)
+	return err
+}
+
+func (c *client) deliver(p *packets.Publish, qos byte, retain bool) {
+	_ = c.write(packets.PUBLISH, func(content packets.Packet) {
+		pb := content.(*packets.Publish)
+		pb.Topic = p.Topic
+		pb.Payload = p.Payload
+		pb.QoS = qos
+		pb.Retain = retain
+		if p.Properties != nil {
+			props := *p.Properties
+			props.TopicAlias = nil
+			pb.Properties = &props
+		}
+		if qos > 0 {
+			pb.PacketID = c.packetID()
+		}
+	})
+}
+
+// packetID must only be called from a write fill function.
+func (c *client) packetID() uint16 {
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+
+	return c.nextID
+}
+
+func (c *client) subscribe(sub packets.SubOptions) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	c.subs[sub.Topic] = sub.QoS
+}
+
+func (c *client) unsubscribe(filter string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	_, ok := c.subs[filter]
+	delete(c.subs, filter)
+
+	return ok
+}
+
+// matches returns the highest QoS of the regular subscriptions matching
+// topic and the QoS of the shared ones by their "$share/<group>/<filter>"
+// filter: a shared subscription is the group and the filter together, so
+// one group with two matching filters gets the message twice.
+func (c *client) matches(topic string) (byte, bool, map[string]byte) {
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+
+	var (
+		qos     byte
+		matched bool
+		shares  map[string]byte
+	)
+	for filter, subQoS := range c.subs {
+		if _, sharedFilter, shared := mqttAuth.SplitSharedTopic(filter); shared {
+			if !matchTopic(sharedFilter, topic) {
+				continue
+			}
+			if shares == nil {
+				shares = make(map[string]byte)
+			}
+			shares[filter] = subQoS
+			continue
+		}
+
+		if !matchTopic(filter, topic) {
+			continue
+		}
+		qos = max(qos, subQoS)
+		matched = true
+	}
+
+	return qos, matched, shares
+}
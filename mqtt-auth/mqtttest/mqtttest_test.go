@@ -0,0 +1,771 @@
+package mqtttest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/commands"
+	commandMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/command-message"
+	presenceMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/presence-message"
+	mqttAuth "github.com/MaxRomanov007/smart-pc-go-lib/mqtt-auth"
+	"github.com/MaxRomanov007/smart-pc-go-lib/mqtt-auth/mqtttest"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	userID       = "u1"
+	commandTopic = "pcs/p1/commands"
+	logTopic     = "pcs/p1/logs"
+	timeout      = 5 * time.Second
+)
+
+type env struct {
+	broker *mqtttest.Broker
+	auth   *mqtttest.Auth
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	auth, err := mqtttest.NewAuth(t.Context(), userID)
+	if err != nil {
+		t.Fatalf("NewAuth: %v", err)
+	}
+	t.Cleanup(auth.Close)
+
+	broker, err := mqtttest.NewBroker(&mqtttest.BrokerOptions{
+		Authenticate: auth.Authenticator(),
+	})
+	if err != nil {
+		t.Fatalf("NewBroker: %v", err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+
+	return &env{broker: broker, auth: auth}
+}
+
+// connect opens a connection with manual acks, setup may adjust the config
+// before connecting, e.g. to set a will.
+func (e *env) connect(
+	t *testing.T,
+	clientID string,
+	setup func(*mqttAuth.ClientConfig),
+) (*mqttAuth.Connection, *mqttAuth.Router) {
+	t.Helper()
+
+	cfg, router, err := mqttAuth.NewClientConfigWithOptionsAndRouter(
+		t.Context(),
+		e.auth,
+		&mqttAuth.Options{
+			ServerURLs:    []string{e.broker.URL().String()},
+			ClientID:      clientID,
+			SessionExpiry: time.Minute,
+			ManualAcks:    true,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewClientConfig: %v", err)
+	}
+	if setup != nil {
+		setup(cfg)
+	}
+
+	// the context bounds the connection, not only the connect
+	connection, err := mqttAuth.NewConnection(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("NewConnection: %v", err)
+	}
+	t.Cleanup(func() { _ = connection.Disconnect(context.Background()) })
+
+	return connection, router
+}
+
+// serviceToken is the TokenProvider of a service connection.
+type serviceToken string
+
+func (t serviceToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (e *env) pool(t *testing.T, size int) *mqttAuth.Pool {
+	t.Helper()
+
+	pool, err := mqttAuth.NewPool(t.Context(), serviceToken(e.auth.AccessToken()), &mqttAuth.PoolOptions{
+		Options: &mqttAuth.Options{
+			ServerURLs: []string{e.broker.URL().String()},
+			ClientID:   "backend",
+		},
+		Username: "backend",
+		Size:     size,
+		Log:      slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	t.Cleanup(func() { _ = pool.Disconnect(context.Background()) })
+
+	return pool
+}
+
+func startExecutor(
+	t *testing.T,
+	connection *mqttAuth.Connection,
+	router *mqttAuth.Router,
+	shareGroup string,
+	command commands.CommandFunc,
+) {
+	t.Helper()
+
+	executor := commands.NewExecutor(connection, router)
+	executor.Set("ping", command)
+
+	if err := executor.StartListen(t.Context(), &commands.StartListenOptions{
+		CommandTopic:       commandTopic,
+		CommandMessageType: "command",
+		LogTopic:           logTopic,
+		LogMessageType:     "log",
+		Log:                slog.New(slog.DiscardHandler),
+		ShareGroup:         shareGroup,
+	}); err != nil {
+		t.Fatalf("StartListen: %v", err)
+	}
+}
+
+// subscribe routes the messages of topic to the returned channel.
+func subscribe(
+	t *testing.T,
+	connection *mqttAuth.Connection,
+	router *mqttAuth.Router,
+	topic string,
+) <-chan *paho.Publish {
+	t.Helper()
+
+	received := make(chan *paho.Publish, 16)
+	router.Handle(topic, func(_ context.Context, p *paho.Publish) error {
+		received <- p
+		return nil
+	}, nil)
+
+	if _, err := connection.Subscribe(t.Context(), &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: 1}},
+	}); err != nil {
+		t.Fatalf("Subscribe(%q): %v", topic, err)
+	}
+
+	return received
+}
+
+func receive(t *testing.T, ch <-chan *paho.Publish) *paho.Publish {
+	t.Helper()
+
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(timeout):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func commandPayload(t *testing.T, command string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(commandMessage.Message{
+		Type: "command",
+		Data: commandMessage.Data{Command: command},
+	})
+	if err != nil {
+		t.Fatalf("marshal command: %v", err)
+	}
+
+	return data
+}
+
+func TestExecutor(t *testing.T) {
+	e := newEnv(t)
+	connection, router := e.connect(t, "executor", nil)
+
+	executed := make(chan string, 1)
+	startExecutor(t, connection, router, "", func(ctx context.Context, msg *commandMessage.Message) error {
+		executed <- mqttAuth.MessageTopic(ctx)
+		return nil
+	})
+	logs := subscribe(t, connection, router, logTopic)
+
+	e.broker.Publish("users/u1/pcs/p1/commands", commandPayload(t, "ping"), false)
+
+	select {
+	case topic := <-executed:
+		if topic != "users/u1/pcs/p1/commands" {
+			t.Errorf("command topic = %q, want %q", topic, "users/u1/pcs/p1/commands")
+		}
+	case <-time.After(timeout):
+		t.Fatal("command not executed")
+	}
+
+	p := receive(t, logs)
+	if p.Topic != "users/u1/pcs/p1/logs" {
+		t.Errorf("log topic = %q, want %q", p.Topic, "users/u1/pcs/p1/logs")
+	}
+
+	var log commands.LogMessage
+	if err := json.Unmarshal(p.Payload, &log); err != nil {
+		t.Fatalf("unmarshal log: %v", err)
+	}
+	if log.Type != "log" || log.Data.Command != "ping" || log.Data.Status != commands.StatusOK {
+		t.Errorf("log = %+v, want an ok log of ping", log)
+	}
+
+	// the command is acknowledged, the log is published with QoS 0
+	eventually(t, func() bool { return e.broker.Acks() == 1 }, "command not acknowledged")
+}
+
+func TestExecutorExpired(t *testing.T) {
+	e := newEnv(t)
+	connection, router := e.connect(t, "executor", nil)
+
+	var executed atomic.Bool
+	startExecutor(t, connection, router, "", func(context.Context, *commandMessage.Message) error {
+		executed.Store(true)
+		return nil
+	})
+	logs := subscribe(t, connection, router, logTopic)
+
+	expiresAt := time.Now().Add(-time.Second)
+	payload, err := json.Marshal(commandMessage.Message{
+		Type: "command",
+		Data: commandMessage.Data{Command: "ping", ExpiresAt: &expiresAt},
+	})
+	if err != nil {
+		t.Fatalf("marshal command: %v", err)
+	}
+	e.broker.Publish("users/u1/pcs/p1/commands", payload, false)
+
+	var log commands.LogMessage
+	if err := json.Unmarshal(receive(t, logs).Payload, &log); err != nil {
+		t.Fatalf("unmarshal log: %v", err)
+	}
+	if log.Data.Command != "ping" || log.Data.Status != commands.StatusExpired {
+		t.Errorf("log = %+v, want an expired log of ping", log)
+	}
+	eventually(t, func() bool { return e.broker.Acks() == 1 }, "expired command not acknowledged")
+	if executed.Load() {
+		t.Error("expired command executed")
+	}
+}
+
+func TestSendTTL(t *testing.T) {
+	e := newEnv(t)
+	connection, router := e.connect(t, "sender", nil)
+	received := subscribe(t, connection, router, commandTopic)
+
+	const ttl = 1500 * time.Millisecond
+	sentAt := time.Now()
+	if err := commands.Send(t.Context(), connection, &commands.SendOptions{
+		Topic:       commandTopic,
+		MessageType: "command",
+		Command:     "ping",
+		TTL:         ttl,
+	}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	p := receive(t, received)
+	// the broker counts in whole seconds, rounded up
+	if p.Properties == nil || p.Properties.MessageExpiry == nil || *p.Properties.MessageExpiry != 2 {
+		t.Errorf("message expiry = %+v, want 2s", p.Properties)
+	}
+
+	var msg commandMessage.Message
+	if err := json.Unmarshal(p.Payload, &msg); err != nil {
+		t.Fatalf("unmarshal command: %v", err)
+	}
+	if at := msg.Data.ExpiresAt; at == nil || at.Before(sentAt.Add(ttl)) || at.After(time.Now().Add(ttl)) {
+		t.Errorf("expiresAt = %v, want %v after sending", at, ttl)
+	}
+}
+
+func TestExecutorSharedSubscription(t *testing.T) {
+	e := newEnv(t)
+
+	const commandsCount = 10
+	var executed [2]atomic.Int32
+	for i := range executed {
+		connection, router := e.connect(t, "replica-"+string(rune('a'+i)), nil)
+		startExecutor(t, connection, router, "replicas", func(context.Context, *commandMessage.Message) error {
+			executed[i].Add(1)
+			return nil
+		})
+	}
+
+	for range commandsCount {
+		e.broker.Publish("users/u1/pcs/p1/commands", commandPayload(t, "ping"), false)
+	}
+
+	eventually(t, func() bool {
+		return executed[0].Load()+executed[1].Load() == commandsCount
+	}, "commands not executed")
+
+	// a redelivery or a delivery to both replicas would show up late
+	time.Sleep(100 * time.Millisecond)
+	if total := executed[0].Load() + executed[1].Load(); total != commandsCount {
+		t.Errorf("%d commands executed, want %d", total, commandsCount)
+	}
+}
+
+func TestSharedSubscriptionPerFilter(t *testing.T) {
+	e := newEnv(t)
+	connection, router := e.connect(t, "shared", nil)
+
+	var received atomic.Int32
+	router.Handle("pcs/+/logs", func(context.Context, *paho.Publish) error {
+		received.Add(1)
+		return nil
+	}, nil)
+
+	// one group, two filters: two shared subscriptions
+	if _, err := connection.Subscribe(t.Context(), &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{
+			{Topic: mqttAuth.SharedTopic("g", "pcs/p1/logs"), QoS: 1},
+			{Topic: mqttAuth.SharedTopic("g", "pcs/+/logs"), QoS: 1},
+		},
+	}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	e.broker.Publish("users/u1/pcs/p1/logs", []byte("log"), false)
+
+	eventually(t, func() bool { return received.Load() == 2 }, "message not delivered per shared subscription")
+	time.Sleep(100 * time.Millisecond)
+	if n := received.Load(); n != 2 {
+		t.Errorf("%d deliveries, want 2", n)
+	}
+}
+
+func TestDeferredAck(t *testing.T) {
+	e := newEnv(t)
+	connection, router := e.connect(t, "deferred", nil)
+
+	acks := make(chan func() error, 1)
+	router.Handle("pcs/p1/jobs", func(ctx context.Context, _ *paho.Publish) error {
+		acks <- mqttAuth.DeferAck(ctx)
+		return nil
+	}, nil)
+	if _, err := connection.Subscribe(t.Context(), &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: "pcs/p1/jobs", QoS: 1}},
+	}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	e.broker.Publish("users/u1/pcs/p1/jobs", []byte("job"), false)
+
+	var ack func() error
+	select {
+	case ack = <-acks:
+	case <-time.After(timeout):
+		t.Fatal("job not received")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := e.broker.Acks(); n != 0 {
+		t.Fatalf("%d acks before DeferAck completed, want 0", n)
+	}
+
+	if err := ack(); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	eventually(t, func() bool { return e.broker.Acks() == 1 }, "job not acknowledged")
+}
+
+func TestRetained(t *testing.T) {
+	e := newEnv(t)
+	connection, router := e.connect(t, "retained", nil)
+
+	e.broker.Publish("users/u1/pcs/p1/config", []byte("v1"), true)
+
+	p := receive(t, subscribe(t, connection, router, "pcs/{pcID}/config"))
+	if string(p.Payload) != "v1" || !p.Retain {
+		t.Errorf("got %q retain=%v, want retained %q", p.Payload, p.Retain, "v1")
+	}
+}
+
+func TestWill(t *testing.T) {
+	e := newEnv(t)
+	e.connect(t, "will", func(cfg *mqttAuth.ClientConfig) {
+		cfg.SetWill(&paho.WillMessage{
+			Topic:   "pcs/p1/status",
+			Payload: []byte("offline"),
+			QoS:     1,
+			Retain:  true,
+		})
+	})
+
+	if _, ok := e.broker.Retained("users/u1/pcs/p1/status"); ok {
+		t.Fatal("will published while connected")
+	}
+
+	e.broker.DropClients()
+
+	eventually(t, func() bool {
+		payload, ok := e.broker.Retained("users/u1/pcs/p1/status")
+		return ok && string(payload) == "offline"
+	}, "will not published")
+}
+
+func TestPool(t *testing.T) {
+	e := newEnv(t)
+	pool := e.pool(t, 2)
+
+	users := pool.User("u2")
+	logs := make(chan string, 1)
+	users.Handle("pcs/{pcID}/logs", func(ctx context.Context, _ *paho.Publish) error {
+		logs <- mqttAuth.Param(ctx, "pcID")
+		return nil
+	}, nil)
+	if _, err := users.Subscribe(t.Context(), &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: "pcs/{pcID}/logs", QoS: 1}},
+	}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// the pool router sees broker topics of every user
+	statuses := subscribe(t, pool.Connections()[0], pool.Router(), "users/+/pcs/+/status")
+
+	if _, err := users.Publish(t.Context(), &paho.Publish{
+		Topic:   "pcs/p1/logs",
+		QoS:     1,
+		Payload: []byte("log"),
+	}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case pcID := <-logs:
+		if pcID != "p1" {
+			t.Errorf("pcID = %q, want %q", pcID, "p1")
+		}
+	case <-time.After(timeout):
+		t.Fatal("log not received")
+	}
+
+	e.broker.Publish("users/u3/pcs/p2/status", []byte("online"), false)
+	if p := receive(t, statuses); p.Topic != "users/u3/pcs/p2/status" {
+		t.Errorf("status topic = %q, want %q", p.Topic, "users/u3/pcs/p2/status")
+	}
+}
+
+func TestConnectionState(t *testing.T) {
+	e := newEnv(t)
+	connection, _ := e.connect(t, "state", func(cfg *mqttAuth.ClientConfig) {
+		cfg.ReconnectBackoff = autopaho.NewConstantBackoff(10 * time.Millisecond)
+	})
+
+	events := make(chan mqttAuth.ConnectionEvent, 16)
+	connection.OnStateChange(func(event mqttAuth.ConnectionEvent) { events <- event })
+	expect := func(previous, state mqttAuth.ConnectionState) {
+		t.Helper()
+
+		select {
+		case event := <-events:
+			if event.Previous != previous || event.State != state {
+				t.Fatalf("event %s -> %s, want %s -> %s", event.Previous, event.State, previous, state)
+			}
+		case <-time.After(timeout):
+			t.Fatalf("no %s -> %s event", previous, state)
+		}
+	}
+
+	if status := connection.Status(); status.State != mqttAuth.StateConnected || status.ConnectedAt.IsZero() {
+		t.Fatalf("status = %+v, want connected", status)
+	}
+
+	e.broker.DropClients()
+	expect(mqttAuth.StateConnected, mqttAuth.StateDisconnected)
+	expect(mqttAuth.StateDisconnected, mqttAuth.StateConnected)
+	if status := connection.Status(); status.Reconnects != 1 || status.Renewals != 0 || status.LastError == nil {
+		t.Errorf("status after reconnect = %+v, want 1 reconnect and the error of the drop", status)
+	}
+
+	// the old manager going down during a renew is no disconnect
+	if err := connection.Renew(t.Context()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	expect(mqttAuth.StateConnected, mqttAuth.StateRenewing)
+	expect(mqttAuth.StateRenewing, mqttAuth.StateConnected)
+	if status := connection.Status(); status.Reconnects != 1 || status.Renewals != 1 {
+		t.Errorf("status after renew = %+v, want 1 reconnect and 1 renewal", status)
+	}
+
+	if err := connection.Disconnect(t.Context()); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	expect(mqttAuth.StateConnected, mqttAuth.StateClosed)
+
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %s -> %s", event.Previous, event.State)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPoolNotAuthorized(t *testing.T) {
+	e := newEnv(t)
+	pool := e.pool(t, 1)
+
+	// the ACL of u2 denies the publish, the connection is shared with u3
+	e.broker.SetACL(func(_ mqtttest.ConnectInfo, action mqtttest.Action, topic string) bool {
+		return action != mqtttest.ActionPublish || !strings.HasPrefix(topic, "users/u2/")
+	})
+
+	_, err := pool.User("u2").Publish(t.Context(), &paho.Publish{Topic: "pcs/p1/logs", QoS: 1})
+	if !errors.Is(err, mqttAuth.ErrNotAuthorized) {
+		t.Fatalf("Publish error = %v, want %v", err, mqttAuth.ErrNotAuthorized)
+	}
+	if n := pool.Connections()[0].Status().Renewals; n != 0 {
+		t.Errorf("%d renewals of the shared connection, want 0", n)
+	}
+
+	if _, err := pool.User("u3").Publish(t.Context(), &paho.Publish{Topic: "pcs/p1/logs", QoS: 1}); err != nil {
+		t.Errorf("Publish of another user: %v", err)
+	}
+}
+
+func TestRenewSingleFlight(t *testing.T) {
+	e := newEnv(t)
+	connection, router := e.connect(t, "renew", nil)
+
+	// the disconnect of a renew waits for the handler in progress
+	entered, release := make(chan struct{}), make(chan struct{})
+	router.Handle("pcs/p1/jobs", func(context.Context, *paho.Publish) error {
+		close(entered)
+		<-release
+		return nil
+	}, nil)
+	if _, err := connection.Subscribe(t.Context(), &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: "pcs/p1/jobs", QoS: 1}},
+	}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	e.broker.Publish("users/u1/pcs/p1/jobs", []byte("job"), false)
+	select {
+	case <-entered:
+	case <-time.After(timeout):
+		t.Fatal("job not received")
+	}
+
+	const callers = 3
+	errs := make(chan error, callers)
+	for range callers {
+		go func() { errs <- connection.Renew(t.Context()) }()
+	}
+	eventually(t, func() bool {
+		return connection.Status().State == mqttAuth.StateRenewing
+	}, "renew not started")
+	close(release)
+
+	for range callers {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("Renew: %v", err)
+			}
+		case <-time.After(timeout):
+			t.Fatal("Renew did not return")
+		}
+	}
+	if n := connection.Status().Renewals; n != 1 {
+		t.Errorf("%d renewals, want the callers to share one", n)
+	}
+}
+
+// denyUntilRenewed makes the broker deny publishing on topic to the first
+// connection of the client, the one a Renew replaces.
+func (e *env) denyUntilRenewed(t *testing.T, topic string) {
+	t.Helper()
+
+	var connects atomic.Int32
+	authenticate := e.auth.Authenticator()
+	e.broker.SetAuthenticator(func(client mqtttest.ConnectInfo) byte {
+		connects.Add(1)
+		return authenticate(client)
+	})
+	e.broker.SetACL(func(_ mqtttest.ConnectInfo, action mqtttest.Action, filter string) bool {
+		return action != mqtttest.ActionPublish || filter != topic || connects.Load() > 1
+	})
+}
+
+func TestRenewOnNotAuthorized(t *testing.T) {
+	e := newEnv(t)
+	e.denyUntilRenewed(t, "users/u1/pcs/p1/results")
+	connection, _ := e.connect(t, "renew", nil)
+
+	if _, err := connection.Publish(t.Context(), &paho.Publish{Topic: "pcs/p1/results", QoS: 1}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if status := connection.Status(); status.Renewals != 1 || status.State != mqttAuth.StateConnected {
+		t.Errorf("status = %+v, want connected after one renewal", status)
+	}
+}
+
+func TestRenewInHandler(t *testing.T) {
+	e := newEnv(t)
+	e.denyUntilRenewed(t, "users/u1/pcs/p1/results")
+	connection, router := e.connect(t, "renew", nil)
+
+	// the renew can not wait in the handler for the manager the handler
+	// runs on to disconnect
+	published := make(chan error, 1)
+	startExecutor(t, connection, router, "", func(ctx context.Context, _ *commandMessage.Message) error {
+		_, err := connection.Publish(ctx, &paho.Publish{Topic: "pcs/p1/results", QoS: 1})
+		published <- err
+		return nil
+	})
+	e.broker.Publish("users/u1/pcs/p1/commands", commandPayload(t, "ping"), false)
+
+	select {
+	case err := <-published:
+		if !errors.Is(err, mqttAuth.ErrNotAuthorized) {
+			t.Fatalf("Publish error = %v, want %v", err, mqttAuth.ErrNotAuthorized)
+		}
+	case <-time.After(timeout):
+		t.Fatal("Publish in the handler did not return")
+	}
+
+	eventually(t, func() bool {
+		status := connection.Status()
+		return status.Renewals == 1 && status.State == mqttAuth.StateConnected
+	}, "connection not renewed in the background")
+	if _, err := connection.Publish(t.Context(), &paho.Publish{Topic: "pcs/p1/results", QoS: 1}); err != nil {
+		t.Errorf("Publish after renew: %v", err)
+	}
+}
+
+func presencePayload(t *testing.T, status string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(presenceMessage.Message{
+		Type: "presence",
+		Data: presenceMessage.Data{Status: status},
+	})
+	if err != nil {
+		t.Fatalf("marshal presence: %v", err)
+	}
+
+	return data
+}
+
+func TestPresenceWatcherServiceConnection(t *testing.T) {
+	e := newEnv(t)
+	pool := e.pool(t, 1)
+
+	watcher, err := mqttAuth.NewPresenceWatcher(pool.Connections()[0], pool.Router(), &mqttAuth.PresenceWatcherOptions{
+		TopicFilter: "users/+/pcs/+/status",
+		MessageType: "presence",
+		Log:         slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("NewPresenceWatcher: %v", err)
+	}
+
+	events := make(chan mqttAuth.PresenceEvent, 4)
+	watcher.OnChange(func(event mqttAuth.PresenceEvent) { events <- event })
+	if err := watcher.Start(t.Context()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := watcher.Start(t.Context()); err == nil {
+		t.Error("second Start succeeded")
+	}
+
+	e.broker.Publish("users/u2/pcs/p1/status", presencePayload(t, presenceMessage.StatusOnline), true)
+	e.broker.Publish("users/u3/pcs/p2/status", presencePayload(t, presenceMessage.StatusOnline), true)
+
+	want := map[string]mqttAuth.PresenceEvent{
+		"users/u2/pcs/p1/status": {UserID: "u2", PcID: "p1"},
+		"users/u3/pcs/p2/status": {UserID: "u3", PcID: "p2"},
+	}
+	for range want {
+		select {
+		case event := <-events:
+			w, ok := want[event.Key]
+			if !ok {
+				t.Fatalf("unexpected event key %q", event.Key)
+			}
+			if event.UserID != w.UserID || event.PcID != w.PcID ||
+				event.Current != presenceMessage.StatusOnline {
+				t.Errorf("event = %+v, want online of %s/%s", event, w.UserID, w.PcID)
+			}
+		case <-time.After(timeout):
+			t.Fatal("presence event not received")
+		}
+	}
+
+	if data, ok := watcher.Get("users/u2/pcs/p1/status"); !ok || data.Status != presenceMessage.StatusOnline {
+		t.Errorf("Get = %+v, %v, want online", data, ok)
+	}
+}
+
+func TestPresenceGoOfflineStopsHeartbeat(t *testing.T) {
+	e := newEnv(t)
+
+	presence, err := mqttAuth.NewPresence(&mqttAuth.PresenceOptions{
+		PcID:              "p1",
+		MessageType:       "presence",
+		Hostname:          "host",
+		HeartbeatInterval: 10 * time.Millisecond,
+		QoS:               1,
+		Log:               slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("NewPresence: %v", err)
+	}
+
+	connection, _ := e.connect(t, "presence", func(cfg *mqttAuth.ClientConfig) {
+		if err := cfg.SetPresence(presence); err != nil {
+			t.Fatalf("SetPresence: %v", err)
+		}
+	})
+
+	status := func() string {
+		payload, ok := e.broker.Retained("users/u1/pcs/p1/status")
+		if !ok {
+			return ""
+		}
+		var msg presenceMessage.Message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("unmarshal presence: %v", err)
+		}
+		return msg.Data.Status
+	}
+
+	presence.StartHeartbeat(t.Context(), connection)
+	eventually(t, func() bool { return status() == presenceMessage.StatusOnline }, "online not published")
+
+	if err := presence.GoOffline(t.Context(), connection); err != nil {
+		t.Fatalf("GoOffline: %v", err)
+	}
+
+	// heartbeats would have overwritten the status several times by now
+	time.Sleep(100 * time.Millisecond)
+	if got := status(); got != presenceMessage.StatusOffline {
+		t.Errorf("status = %q after GoOffline, want %q", got, presenceMessage.StatusOffline)
+	}
+}
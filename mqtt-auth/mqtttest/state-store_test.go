@@ -0,0 +1,160 @@
+package mqtttest_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	mqttMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/mqtt-message"
+	mqttAuth "github.com/MaxRomanov007/smart-pc-go-lib/mqtt-auth"
+)
+
+const stateTopic = "users/u1/pcs/p1/state/"
+
+// stateEvents collects the change events of a store.
+type stateEvents struct {
+	mu     sync.Mutex
+	events []mqttAuth.StateEvent[int]
+}
+
+func (s *stateEvents) add(e mqttAuth.StateEvent[int]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+}
+
+func (s *stateEvents) get() []mqttAuth.StateEvent[int] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]mqttAuth.StateEvent[int](nil), s.events...)
+}
+
+func newStateStore(t *testing.T, e *env) (*mqttAuth.StateStore[int], *stateEvents) {
+	t.Helper()
+
+	connection, router := e.connect(t, "state", nil)
+	store, err := mqttAuth.NewStateStore[int](connection, router, &mqttAuth.StateStoreOptions{
+		Prefix:       "pcs/p1/state",
+		MessageType:  "state",
+		RetainedWait: 100 * time.Millisecond,
+		Log:          slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatalf("NewStateStore: %v", err)
+	}
+
+	events := new(stateEvents)
+	store.OnChange(events.add)
+
+	return store, events
+}
+
+func statePayload(t *testing.T, value int) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(mqttMessage.Message[int]{Type: "state", Data: value})
+	if err != nil {
+		t.Fatalf("marshal state: %v", err)
+	}
+
+	return data
+}
+
+func TestStateStoreGet(t *testing.T) {
+	e := newEnv(t)
+	e.broker.Publish(stateTopic+"volume", statePayload(t, 7), true)
+	store, _ := newStateStore(t, e)
+
+	// the retained value arrives with the subscription Get makes
+	if value, ok, err := store.Get(t.Context(), "volume"); err != nil || !ok || value != 7 {
+		t.Errorf("Get(volume) = %d, %v, %v, want 7", value, ok, err)
+	}
+	if value, ok, err := store.Get(t.Context(), "brightness"); err != nil || ok {
+		t.Errorf("Get(brightness) = %d, %v, %v, want nothing", value, ok, err)
+	}
+}
+
+func TestStateStoreDelete(t *testing.T) {
+	e := newEnv(t)
+	store, events := newStateStore(t, e)
+
+	if err := store.Set(t.Context(), "volume", 7); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, ok := e.broker.Retained(stateTopic + "volume"); !ok {
+		t.Fatal("state not retained")
+	}
+
+	if err := store.Delete(t.Context(), "volume"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := e.broker.Retained(stateTopic + "volume"); ok {
+		t.Error("retained state not cleared")
+	}
+	if value, ok, err := store.Get(t.Context(), "volume"); err != nil || ok {
+		t.Errorf("Get after Delete = %d, %v, %v, want nothing", value, ok, err)
+	}
+
+	got := events.get()
+	if len(got) != 2 || got[0].Value != 7 || !got[1].Deleted {
+		t.Errorf("events = %+v, want the set and the delete", got)
+	}
+}
+
+func TestStateStoreChangeEvents(t *testing.T) {
+	e := newEnv(t)
+	store, events := newStateStore(t, e)
+
+	if err := store.Watch(t.Context(), "+"); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	// the echo of the own publish and a repeated value are no changes
+	if err := store.Set(t.Context(), "volume", 7); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	e.broker.Publish(stateTopic+"volume", statePayload(t, 7), true)
+	e.broker.Publish(stateTopic+"volume", statePayload(t, 8), true)
+
+	eventually(t, func() bool { return len(events.get()) >= 2 }, "change not received")
+	time.Sleep(100 * time.Millisecond)
+
+	got := events.get()
+	if len(got) != 2 || got[0].Value != 7 || got[1].Value != 8 {
+		t.Errorf("events = %+v, want 7 and 8 once each", got)
+	}
+	if list := store.List(); list["volume"] != 8 {
+		t.Errorf("List = %v, want volume 8", list)
+	}
+}
+
+func TestStateStoreUnwatch(t *testing.T) {
+	e := newEnv(t)
+	e.broker.Publish(stateTopic+"volume", statePayload(t, 7), true)
+	store, events := newStateStore(t, e)
+
+	if err := store.Watch(t.Context(), "volume"); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	eventually(t, func() bool { return len(store.List()) == 1 }, "retained state not cached")
+
+	if err := store.Unwatch(t.Context(), "volume"); err != nil {
+		t.Fatalf("Unwatch: %v", err)
+	}
+	if list := store.List(); len(list) != 0 {
+		t.Errorf("List after Unwatch = %v, want empty", list)
+	}
+
+	e.broker.Publish(stateTopic+"volume", statePayload(t, 8), true)
+	time.Sleep(100 * time.Millisecond)
+	if list := store.List(); len(list) != 0 {
+		t.Errorf("List = %v, want changes of the unwatched key ignored", list)
+	}
+	if n := len(events.get()); n != 1 {
+		t.Errorf("%d events, want only the retained value", n)
+	}
+}
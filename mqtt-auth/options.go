@@ -0,0 +1,287 @@
+package mqttAuth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	userScope "github.com/MaxRomanov007/smart-pc-go-lib/user-scope"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultKeepAlive      = 30 * time.Second
+	defaultConnectTimeout = 10 * time.Second
+	defaultBackoffMin     = time.Second
+	defaultBackoffMax     = time.Minute
+	backoffFactor         = 2
+
+	clientIDPrefix = "smart-pc-"
+	clientIDFolder = "mqtt-client-ids"
+)
+
+// Options configures the broker connection. It can be loaded from YAML,
+// durations are written as "30s", "1h" and so on.
+type Options struct {
+	ServerURLs []string `yaml:"server_urls"`
+	// ClientID is used as is when set. Otherwise it is read from ClientIDPath,
+	// or generated and stored there on first use, so the broker can resume
+	// the session after a restart. ClientIDPath defaults to a file per
+	// username in the user cache dir.
+	ClientID     string              `yaml:"client_id"`
+	ClientIDPath userScope.CachePath `yaml:"client_id_path"`
+	// KeepAlive defaults to 30s when nil, 0 disables it.
+	KeepAlive      *time.Duration `yaml:"keep_alive"`
+	SessionExpiry  time.Duration  `yaml:"session_expiry"`
+	CleanStart     bool           `yaml:"clean_start"`
+	ConnectTimeout time.Duration  `yaml:"connect_timeout"`
+	Backoff        BackoffOptions `yaml:"backoff"`
+	// ManualAcks delays acknowledgement of QoS 1 and 2 messages until the
+	// router handlers are done with them (see Ack and DeferAck), so a message
+	// is redelivered if the process dies mid-handling. Requires a session
+	// expiry.
+	ManualAcks bool              `yaml:"manual_acks"`
+	TLS        *TLSOptions       `yaml:"tls"`
+	WebSocket  *WebSocketOptions `yaml:"websocket"`
+	// DisableTokenAuth stops sending the OAuth token as the CONNECT password
+	// for brokers that authenticate clients by certificate only.
+	DisableTokenAuth bool `yaml:"disable_token_auth"`
+	// Log receives the errors of callbacks which can not return them.
+	// Defaults to slog.Default.
+	Log *slog.Logger `yaml:"-"`
+}
+
+type BackoffOptions struct {
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+}
+
+// DefaultOptions returns options with every optional field set.
+// ServerURLs still have to be provided.
+func DefaultOptions() *Options {
+	keepAlive := defaultKeepAlive
+
+	return &Options{
+		KeepAlive:      &keepAlive,
+		ConnectTimeout: defaultConnectTimeout,
+		Backoff: BackoffOptions{
+			Min: defaultBackoffMin,
+			Max: defaultBackoffMax,
+		},
+	}
+}
+
+func (o *Options) withDefaults() *Options {
+	result := *o
+	defaults := DefaultOptions()
+
+	if result.Log == nil {
+		result.Log = slog.Default()
+	}
+	if result.KeepAlive == nil {
+		result.KeepAlive = defaults.KeepAlive
+	}
+	if result.ConnectTimeout == 0 {
+		result.ConnectTimeout = defaults.ConnectTimeout
+	}
+	if result.Backoff.Min == 0 {
+		result.Backoff.Min = defaults.Backoff.Min
+	}
+	if result.Backoff.Max == 0 {
+		result.Backoff.Max = max(defaults.Backoff.Max, result.Backoff.Min*backoffFactor)
+	}
+
+	return &result
+}
+
+func (o *Options) validate() error {
+	var errs []error
+
+	if len(o.ServerURLs) == 0 {
+		errs = append(errs, errors.New("at least one server url required"))
+	}
+	for _, raw := range o.ServerURLs {
+		if _, err := parseServerURL(raw); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if *o.KeepAlive < 0 || *o.KeepAlive > math.MaxUint16*time.Second {
+		errs = append(errs, fmt.Errorf("keep alive must be between 0 and %ds", math.MaxUint16))
+	}
+	if o.SessionExpiry < 0 || o.SessionExpiry > math.MaxUint32*time.Second {
+		errs = append(
+			errs,
+			fmt.Errorf("session expiry must be between 0 and %ds", uint32(math.MaxUint32)),
+		)
+	}
+	if o.ConnectTimeout < 0 {
+		errs = append(errs, errors.New("connect timeout must not be negative"))
+	}
+	if o.Backoff.Min <= 0 {
+		errs = append(errs, errors.New("backoff min must be positive"))
+	}
+	if o.Backoff.Max < o.Backoff.Min*backoffFactor {
+		errs = append(
+			errs,
+			fmt.Errorf("backoff max must be at least %d times backoff min", backoffFactor),
+		)
+	}
+	if o.ManualAcks && o.SessionExpiry == 0 {
+		errs = append(errs, errors.New("manual acks require a session expiry"))
+	}
+	if o.TLS != nil {
+		if err := o.TLS.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.WebSocket != nil {
+		if err := o.WebSocket.validate(o.ServerURLs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.DisableTokenAuth && (o.TLS == nil || !o.TLS.hasCert()) {
+		errs = append(errs, errors.New("disabling token auth requires a tls client certificate"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// passwordToken reports whether the token goes to the CONNECT password.
+func (o *Options) passwordToken() bool {
+	if o.DisableTokenAuth {
+		return false
+	}
+
+	return o.WebSocket == nil || !o.WebSocket.TokenInHeader
+}
+
+// apply validates the options and copies them into cfg. username picks the
+// default client id file.
+func (o *Options) apply(
+	ctx context.Context,
+	cfg *autopaho.ClientConfig,
+	tokens TokenProvider,
+	username string,
+) error {
+	const op = "mqtt-auth.options.apply"
+
+	opts := o.withDefaults()
+	if err := opts.validate(); err != nil {
+		return fmt.Errorf("%s: invalid options: %w", op, err)
+	}
+
+	urls := make([]*url.URL, 0, len(opts.ServerURLs))
+	for _, raw := range opts.ServerURLs {
+		u, _ := parseServerURL(raw)
+		urls = append(urls, u)
+	}
+
+	clientID, err := opts.clientID(username)
+	if err != nil {
+		return fmt.Errorf("%s: failed to get client id: %w", op, err)
+	}
+
+	if opts.TLS != nil {
+		tlsCfg, err := opts.TLS.tlsConfig(urls)
+		if err != nil {
+			return fmt.Errorf("%s: failed to build tls config: %w", op, err)
+		}
+		cfg.TlsCfg = tlsCfg
+	}
+
+	if opts.WebSocket != nil {
+		cfg.WebSocketCfg = opts.WebSocket.webSocketConfig(ctx, tokens, opts.Log)
+	}
+
+	cfg.ServerUrls = urls
+	cfg.ClientID = clientID
+	cfg.KeepAlive = uint16(*opts.KeepAlive / time.Second)
+	cfg.SessionExpiryInterval = uint32(opts.SessionExpiry / time.Second)
+	cfg.CleanStartOnInitialConnection = opts.CleanStart
+	cfg.ConnectTimeout = opts.ConnectTimeout
+	cfg.ReconnectBackoff = autopaho.NewExponentialBackoff(
+		opts.Backoff.Min,
+		opts.Backoff.Max,
+		opts.Backoff.Min*backoffFactor,
+		backoffFactor,
+	)
+	cfg.EnableManualAcknowledgment = opts.ManualAcks
+
+	return nil
+}
+
+func (o *Options) clientID(username string) (string, error) {
+	if o.ClientID != "" {
+		return o.ClientID, nil
+	}
+
+	path := o.ClientIDPath
+	if path == "" {
+		var err error
+		path, err = defaultClientIDPath(username)
+		if err != nil {
+			// no cache dir, let the broker assign an id
+			return "", nil
+		}
+	}
+
+	return loadOrCreateClientID(string(path))
+}
+
+// defaultClientIDPath keeps the client ids of the users of one OS account
+// apart, so their sessions do not take over each other.
+func defaultClientIDPath(username string) (userScope.CachePath, error) {
+	name := base64.RawURLEncoding.EncodeToString([]byte(username))
+	return userScope.NewCachePath(filepath.Join(clientIDFolder, name))
+}
+
+func loadOrCreateClientID(path string) (string, error) {
+	const op = "mqtt-auth.options.loadOrCreateClientID"
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s: failed to read client id: %w", op, err)
+	}
+
+	id := clientIDPrefix + uuid.NewString()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("%s: failed to create directory: %w", op, err)
+	}
+	if err := os.WriteFile(path, []byte(id), 0o600); err != nil {
+		return "", fmt.Errorf("%s: failed to write client id: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func parseServerURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url %q: %w", raw, err)
+	}
+
+	switch u.Scheme {
+	case "mqtt", "tcp", "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "ws", "wss":
+	default:
+		return nil, fmt.Errorf("server url %q has unsupported scheme %q", raw, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("server url %q has no host", raw)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,118 @@
+package mqttAuth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOptionsKeepAlive(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	tests := []struct {
+		name string
+		yaml string
+		want uint16
+	}{
+		{"unset", "server_urls: [mqtt://localhost:1883]", 30},
+		{"disabled", "server_urls: [mqtt://localhost:1883]\nkeep_alive: 0s", 0},
+		{"set", "server_urls: [mqtt://localhost:1883]\nkeep_alive: 1m", 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := new(Options)
+			if err := yaml.Unmarshal([]byte(tt.yaml), opts); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+
+			cfg := new(autopaho.ClientConfig)
+			if err := opts.apply(context.Background(), cfg, nil, "u1"); err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if cfg.KeepAlive != tt.want {
+				t.Errorf("KeepAlive = %d, want %d", cfg.KeepAlive, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	negative := -time.Second
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"no server", Options{}, "at least one server url required"},
+		{"bad scheme", Options{ServerURLs: []string{"http://x"}}, "unsupported scheme"},
+		{"negative keep alive", Options{
+			ServerURLs: []string{"mqtt://x"},
+			KeepAlive:  &negative,
+		}, "keep alive must be between"},
+		{"manual acks without session", Options{
+			ServerURLs: []string{"mqtt://x"},
+			ManualAcks: true,
+		}, "manual acks require a session expiry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.withDefaults().validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionsClientID(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+
+	opts := &Options{}
+
+	first, err := opts.clientID("user/1")
+	if err != nil {
+		t.Fatalf("clientID: %v", err)
+	}
+	if !strings.HasPrefix(first, clientIDPrefix) {
+		t.Errorf("client id %q lacks prefix %q", first, clientIDPrefix)
+	}
+
+	again, err := opts.clientID("user/1")
+	if err != nil {
+		t.Fatalf("clientID: %v", err)
+	}
+	if again != first {
+		t.Errorf("client id not persisted: %q, then %q", first, again)
+	}
+
+	other, err := opts.clientID("user2")
+	if err != nil {
+		t.Fatalf("clientID: %v", err)
+	}
+	if other == first {
+		t.Error("users share a client id")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cache, "smart-pc", clientIDFolder))
+	if err != nil {
+		t.Fatalf("read cache dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d client id files, want 2", len(entries))
+	}
+
+	explicit := &Options{ClientID: "fixed"}
+	if id, _ := explicit.clientID("user/1"); id != "fixed" {
+		t.Errorf("client id = %q, want %q", id, "fixed")
+	}
+}
@@ -0,0 +1,202 @@
+package mqttAuth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const defaultPoolSize = 1
+
+type PoolOptions struct {
+	// Broker settings shared by every connection. The client id, when set or
+	// loaded from ClientIDPath, gets a "-<index>" suffix per connection.
+	Options *Options
+	// Username identifies the service to the broker.
+	Username string
+	Size     int
+	Log      *slog.Logger
+}
+
+func (o *PoolOptions) check() error {
+	errs := make([]error, 0, 3)
+
+	if o.Options == nil {
+		errs = append(errs, errors.New("options required"))
+	}
+	if o.Username == "" {
+		errs = append(errs, errors.New("username required"))
+	}
+	if o.Log == nil {
+		errs = append(errs, errors.New("log required"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Pool is a service mode connection for backends talking to many users.
+// It authenticates once per broker connection with a service token and
+// spreads the users over a small number of connections, so all traffic of
+// one user goes through the same connection. Messages received by every
+// connection go to a single router.
+type Pool struct {
+	conns  []*Connection
+	router *Router
+}
+
+// NewPool opens opts.Size broker connections authenticated with tokens,
+// typically a client credentials token source.
+func NewPool(ctx context.Context, tokens TokenProvider, opts *PoolOptions) (*Pool, error) {
+	const op = "mqtt-auth.pool.NewPool"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	size := opts.Size
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+
+	baseClientID, err := opts.Options.clientID(opts.Username)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to get client id: %w", op, err)
+	}
+
+	p := &Pool{
+		conns:  make([]*Connection, 0, size),
+		router: NewRouter(NewTopicFactory("")),
+	}
+	p.router.SetLogger(opts.Log)
+
+	for i := range size {
+		connOpts := *opts.Options
+		connOpts.ClientIDPath = ""
+		if baseClientID != "" {
+			connOpts.ClientID = fmt.Sprintf("%s-%d", baseClientID, i)
+		}
+
+		cfg, err := NewServiceClientConfig(ctx, tokens, opts.Username, &connOpts)
+		if err != nil {
+			_ = p.Disconnect(context.Background())
+			return nil, fmt.Errorf("%s: failed to create client config: %w", op, err)
+		}
+
+		cfg.router = p.router
+
+		connection, err := NewConnection(ctx, cfg)
+		if err != nil {
+			_ = p.Disconnect(context.Background())
+			return nil, fmt.Errorf("%s: failed to create connection %d: %w", op, i, err)
+		}
+
+		p.conns = append(p.conns, connection)
+	}
+
+	return p, nil
+}
+
+// User returns a client addressing the topics of userID.
+func (p *Pool) User(userID string) *UserClient {
+	return &UserClient{
+		conn:         p.conns[p.index(userID)],
+		router:       p.router,
+		topicFactory: NewTopicFactory(userID),
+	}
+}
+
+// Connections returns the underlying connections, e.g. to watch their status.
+func (p *Pool) Connections() []*Connection {
+	return slices.Clone(p.conns)
+}
+
+// Router returns the router fed by every connection of the pool. Its
+// patterns are broker topics, so together with one of the Connections it
+// runs helpers spanning many users, such as a PresenceWatcher on
+// "users/+/pcs/+/status".
+func (p *Pool) Router() *Router {
+	return p.router
+}
+
+func (p *Pool) Disconnect(ctx context.Context) error {
+	const op = "mqtt-auth.pool.Disconnect"
+
+	var errs []error
+	for _, conn := range p.conns {
+		if err := conn.Disconnect(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (p *Pool) index(userID string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+
+	return int(h.Sum32() % uint32(len(p.conns)))
+}
+
+// UserClient scopes the pool to a single user: topics are relative to the
+// user the same way they are on a user Connection. It resolves the topics
+// itself and hands the broker topics to the pool.
+type UserClient struct {
+	conn         *Connection
+	router       *Router
+	topicFactory *TopicFactory
+}
+
+func (u *UserClient) TopicFactory() *TopicFactory {
+	return u.topicFactory
+}
+
+func (u *UserClient) Subscribe(ctx context.Context, s *paho.Subscribe) (*paho.Suback, error) {
+	for i := range s.Subscriptions {
+		s.Subscriptions[i].Topic = u.topicFactory.Resolve(s.Subscriptions[i].Topic)
+	}
+
+	return u.conn.subscribe(ctx, s)
+}
+
+func (u *UserClient) Unsubscribe(ctx context.Context, us *paho.Unsubscribe) (*paho.Unsuback, error) {
+	for i := range us.Topics {
+		us.Topics[i] = u.topicFactory.Resolve(us.Topics[i])
+	}
+
+	return u.conn.unsubscribe(ctx, us)
+}
+
+func (u *UserClient) Publish(ctx context.Context, p *paho.Publish) (*paho.PublishResponse, error) {
+	p.Topic = u.topicFactory.Resolve(p.Topic)
+
+	return u.conn.publish(ctx, p)
+}
+
+func (u *UserClient) PublishViaQueue(ctx context.Context, p *autopaho.QueuePublish) error {
+	p.Topic = u.topicFactory.Resolve(p.Topic)
+
+	return u.conn.publishViaQueue(ctx, p)
+}
+
+func (u *UserClient) Handle(pattern string, h HandlerFunc, opts *HandleOptions) {
+	u.router.handle(u.topicFactory.Resolve(pattern), h, opts)
+}
+
+func (u *UserClient) UnregisterHandler(pattern string) {
+	u.router.unregisterHandler(u.topicFactory.Resolve(pattern))
+}
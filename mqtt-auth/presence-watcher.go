@@ -0,0 +1,328 @@
+package mqttAuth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	presenceMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/presence-message"
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type PresenceWatcherOptions struct {
+	// TopicFilter selects the status topics to watch, e.g. "pcs/+/status".
+	TopicFilter string
+	MessageType string
+	// Timeout marks a PC offline when no heartbeat arrived for this long.
+	// Zero disables the check.
+	Timeout time.Duration
+	Log     *slog.Logger
+}
+
+func (o *PresenceWatcherOptions) check() error {
+	errs := make([]error, 0, 3)
+
+	if o.TopicFilter == "" {
+		errs = append(errs, errors.New("topic filter required"))
+	}
+	if o.MessageType == "" {
+		errs = append(errs, errors.New("message type required"))
+	}
+	if o.Log == nil {
+		errs = append(errs, errors.New("log required"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+type PresenceEvent struct {
+	// Key is the status topic relative to the user prefix, or the broker
+	// topic on a service connection, which has no user prefix.
+	Key      string
+	UserID   string
+	PcID     string
+	Previous string
+	Current  string
+	Data     presenceMessage.Data
+}
+
+type PresenceHandler func(PresenceEvent)
+
+// PresenceWatcher tracks the presence of many PCs and emits an event each
+// time a PC changes its status.
+type PresenceWatcher struct {
+	opts         PresenceWatcherOptions
+	connection   *Connection
+	router       *Router
+	topicFactory *TopicFactory
+
+	mu       sync.RWMutex
+	states   map[string]*presenceState
+	handlers []PresenceHandler
+	started  bool
+	cancel   context.CancelFunc
+}
+
+type presenceState struct {
+	data       presenceMessage.Data
+	receivedAt time.Time
+}
+
+func NewPresenceWatcher(
+	connection *Connection,
+	router *Router,
+	opts *PresenceWatcherOptions,
+) (*PresenceWatcher, error) {
+	const op = "mqtt-auth.presence-watcher.NewPresenceWatcher"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	return &PresenceWatcher{
+		opts:         *opts,
+		connection:   connection,
+		router:       router,
+		topicFactory: connection.topicFactory,
+		states:       make(map[string]*presenceState),
+	}, nil
+}
+
+func (w *PresenceWatcher) OnChange(h PresenceHandler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.handlers = append(w.handlers, h)
+}
+
+// Start subscribes to the presence topics. It fails if the watcher is
+// already started, Stop it first.
+func (w *PresenceWatcher) Start(ctx context.Context) error {
+	const op = "mqtt-auth.presence-watcher.Start"
+
+	w.mu.Lock()
+	if w.started {
+		w.mu.Unlock()
+		return fmt.Errorf("%s: already started", op)
+	}
+	w.started = true
+	// set before subscribing, so a concurrent Stop ends the expiry too
+	expireCtx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.mu.Unlock()
+
+	w.router.Handle(w.opts.TopicFilter, w.messageHandler(), nil)
+
+	if _, err := w.connection.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{
+			{
+				Topic: w.opts.TopicFilter,
+				QoS:   1,
+			},
+		},
+	}); err != nil {
+		w.router.UnregisterHandler(w.opts.TopicFilter)
+		cancel()
+
+		w.mu.Lock()
+		w.started = false
+		w.cancel = nil
+		w.mu.Unlock()
+
+		return fmt.Errorf("%s: failed to subscribe on topic: %w", op, err)
+	}
+
+	if w.opts.Timeout > 0 {
+		go w.expire(expireCtx)
+	}
+
+	return nil
+}
+
+func (w *PresenceWatcher) Stop(ctx context.Context) error {
+	const op = "mqtt-auth.presence-watcher.Stop"
+
+	w.mu.Lock()
+	cancel := w.cancel
+	w.cancel = nil
+	w.started = false
+	w.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	w.router.UnregisterHandler(w.opts.TopicFilter)
+
+	if _, err := w.connection.Unsubscribe(ctx, &paho.Unsubscribe{
+		Topics: []string{w.opts.TopicFilter},
+	}); err != nil {
+		return fmt.Errorf(
+			"%s: failed to unsubscribe from topic %q: %w",
+			op,
+			w.opts.TopicFilter,
+			err,
+		)
+	}
+
+	return nil
+}
+
+// Get returns the last known presence of the PC publishing on key.
+func (w *PresenceWatcher) Get(key string) (presenceMessage.Data, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	state, ok := w.states[key]
+	if !ok {
+		return presenceMessage.Data{}, false
+	}
+
+	return state.data, true
+}
+
+// List returns a snapshot of all known presences keyed by status topic.
+func (w *PresenceWatcher) List() map[string]presenceMessage.Data {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	result := make(map[string]presenceMessage.Data, len(w.states))
+	for key, state := range w.states {
+		result[key] = state.data
+	}
+
+	return result
+}
+
+func (w *PresenceWatcher) messageHandler() HandlerFunc {
+	return func(_ context.Context, publish *paho.Publish) error {
+		const op = "mqtt-auth.presence-watcher.messageHandler"
+
+		log := w.opts.Log.With(sl.Op(op), slog.String("topic", publish.Topic))
+
+		key, ok := w.key(publish.Topic)
+		if !ok {
+			log.Debug("topic outside of user scope, skipping")
+			return nil
+		}
+
+		// an empty retained message clears the status of a removed PC
+		if len(publish.Payload) == 0 {
+			w.update(key, presenceMessage.Data{Status: presenceMessage.StatusOffline})
+			return nil
+		}
+
+		msg := new(presenceMessage.Message)
+		if err := json.Unmarshal(publish.Payload, msg); err != nil {
+			return fmt.Errorf("%s: failed to unmarshal payload: %w", op, err)
+		}
+
+		if msg.Type != w.opts.MessageType {
+			log.Debug("invalid message type, skipping")
+			return nil
+		}
+
+		w.update(key, msg.Data)
+		return nil
+	}
+}
+
+// key returns the key of a received status topic. A service connection
+// receives the topics of many users, so the broker topic itself is the key
+// as long as it names a user.
+func (w *PresenceWatcher) key(topic string) (string, bool) {
+	if w.topicFactory.UserID() != "" {
+		return w.topicFactory.trimUserTopic(topic)
+	}
+
+	parsed, err := ParseTopic(topic)
+	if err != nil || parsed.UserID == "" {
+		return "", false
+	}
+
+	return topic, true
+}
+
+func (w *PresenceWatcher) update(key string, data presenceMessage.Data) {
+	w.mu.Lock()
+	event, changed := w.setDangerously(key, data)
+	handlers := w.handlers
+	w.mu.Unlock()
+
+	if changed {
+		w.emit(handlers, event)
+	}
+}
+
+func (w *PresenceWatcher) setDangerously(
+	key string,
+	data presenceMessage.Data,
+) (PresenceEvent, bool) {
+	previous := presenceMessage.StatusOffline
+	if state, ok := w.states[key]; ok {
+		previous = state.data.Status
+	}
+	w.states[key] = &presenceState{data: data, receivedAt: time.Now()}
+
+	event := PresenceEvent{
+		Key:      key,
+		Previous: previous,
+		Current:  data.Status,
+		Data:     data,
+	}
+	if topic, err := ParseTopic(w.topicFactory.Resolve(key)); err == nil {
+		event.UserID = topic.UserID
+		event.PcID = topic.PcID
+	}
+
+	return event, previous != data.Status
+}
+
+func (w *PresenceWatcher) emit(handlers []PresenceHandler, event PresenceEvent) {
+	for _, h := range handlers {
+		h(event)
+	}
+}
+
+func (w *PresenceWatcher) expire(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		var events []PresenceEvent
+		for key, state := range w.states {
+			if state.data.Status != presenceMessage.StatusOnline ||
+				time.Since(state.receivedAt) <= w.opts.Timeout {
+				continue
+			}
+
+			data := state.data
+			data.Status = presenceMessage.StatusOffline
+			if event, changed := w.setDangerously(key, data); changed {
+				events = append(events, event)
+			}
+		}
+		handlers := w.handlers
+		w.mu.Unlock()
+
+		for _, event := range events {
+			w.emit(handlers, event)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package mqttAuth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	presenceMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/presence-message"
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const defaultHeartbeatInterval = 30 * time.Second
+
+type PresenceOptions struct {
+	// Topic defaults to the status channel of PcID.
+	Topic             string
+	PcID              string
+	MessageType       string
+	Version           string
+	Hostname          string
+	HeartbeatInterval time.Duration
+	QoS               byte
+	Log               *slog.Logger
+}
+
+func (o *PresenceOptions) check() error {
+	errs := make([]error, 0, 3)
+
+	if o.Topic == "" && o.PcID == "" {
+		errs = append(errs, errors.New("topic or pc id required"))
+	}
+	if o.MessageType == "" {
+		errs = append(errs, errors.New("message type required"))
+	}
+	if o.Log == nil {
+		errs = append(errs, errors.New("log required"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Presence publishes a retained "online" status on every connect, registers
+// a matching retained "offline" will and keeps the status fresh with heartbeats.
+type Presence struct {
+	opts      PresenceOptions
+	startedAt time.Time
+
+	mu        sync.Mutex
+	heartbeat *heartbeat
+}
+
+type heartbeat struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPresence(opts *PresenceOptions) (*Presence, error) {
+	const op = "mqtt-auth.presence.NewPresence"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	p := &Presence{opts: *opts, startedAt: time.Now()}
+
+	if p.opts.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to get hostname: %w", op, err)
+		}
+		p.opts.Hostname = hostname
+	}
+	if p.opts.Topic == "" {
+		p.opts.Topic = PcTopic(p.opts.PcID, ChannelStatus)
+	}
+	if p.opts.HeartbeatInterval <= 0 {
+		p.opts.HeartbeatInterval = defaultHeartbeatInterval
+	}
+
+	return p, nil
+}
+
+// SetPresence registers the offline will and publishes the online status
+// each time the connection comes up. The will is built once, so its SentAt
+// and Uptime are those of the SetPresence call, not of the disconnect.
+func (c *ClientConfig) SetPresence(p *Presence) error {
+	const op = "mqtt-auth.client-config.SetPresence"
+
+	offline, err := p.payload(presenceMessage.StatusOffline)
+	if err != nil {
+		return fmt.Errorf("%s: failed to build offline payload: %w", op, err)
+	}
+
+	c.SetWill(&paho.WillMessage{
+		Retain:  true,
+		QoS:     p.opts.QoS,
+		Topic:   p.opts.Topic,
+		Payload: offline,
+	})
+
+	topic := c.topicFactory.Resolve(p.opts.Topic)
+	onConnectionUp := c.ClientConfig.OnConnectionUp
+	c.ClientConfig.OnConnectionUp = func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+		if onConnectionUp != nil {
+			onConnectionUp(cm, connack)
+		}
+
+		go func() {
+			const op = "mqtt-auth.presence.onConnectionUp"
+
+			log := p.opts.Log.With(sl.Op(op))
+
+			online, err := p.payload(presenceMessage.StatusOnline)
+			if err != nil {
+				log.Error("failed to build online payload", sl.Err(err))
+				return
+			}
+
+			if _, err := cm.Publish(context.Background(), &paho.Publish{
+				Topic:   topic,
+				QoS:     p.opts.QoS,
+				Retain:  true,
+				Payload: online,
+			}); err != nil {
+				log.Warn("failed to publish online status", sl.Err(err))
+			}
+		}()
+	}
+
+	return nil
+}
+
+// StartHeartbeat republishes the online status every HeartbeatInterval
+// until ctx is done or GoOffline is called. A running heartbeat is replaced.
+func (p *Presence) StartHeartbeat(ctx context.Context, conn *Connection) {
+	p.stopHeartbeat()
+
+	ctx, cancel := context.WithCancel(ctx)
+	hb := &heartbeat{cancel: cancel, done: make(chan struct{})}
+
+	p.mu.Lock()
+	p.heartbeat = hb
+	p.mu.Unlock()
+
+	go func() {
+		const op = "mqtt-auth.presence.StartHeartbeat"
+
+		defer close(hb.done)
+
+		log := p.opts.Log.With(sl.Op(op))
+
+		ticker := time.NewTicker(p.opts.HeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.publish(ctx, conn, presenceMessage.StatusOnline); err != nil {
+					log.Warn("failed to publish heartbeat", sl.Err(err))
+				}
+			}
+		}
+	}()
+}
+
+// stopHeartbeat stops the running heartbeat, if any, and waits for it,
+// so no online status is published afterwards.
+func (p *Presence) stopHeartbeat() {
+	p.mu.Lock()
+	hb := p.heartbeat
+	p.heartbeat = nil
+	p.mu.Unlock()
+
+	if hb != nil {
+		hb.cancel()
+		<-hb.done
+	}
+}
+
+// GoOffline stops the heartbeat and publishes the offline status. The broker
+// does not send the will on a graceful disconnect, so call it before
+// Disconnect.
+func (p *Presence) GoOffline(ctx context.Context, conn *Connection) error {
+	const op = "mqtt-auth.presence.GoOffline"
+
+	p.stopHeartbeat()
+
+	if err := p.publish(ctx, conn, presenceMessage.StatusOffline); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (p *Presence) publish(ctx context.Context, conn *Connection, status string) error {
+	const op = "mqtt-auth.presence.publish"
+
+	payload, err := p.payload(status)
+	if err != nil {
+		return fmt.Errorf("%s: failed to build payload: %w", op, err)
+	}
+
+	if _, err := conn.Publish(ctx, &paho.Publish{
+		Topic:   p.opts.Topic,
+		QoS:     p.opts.QoS,
+		Retain:  true,
+		Payload: payload,
+	}); err != nil {
+		return fmt.Errorf("%s: failed to publish status: %w", op, err)
+	}
+
+	return nil
+}
+
+func (p *Presence) payload(status string) ([]byte, error) {
+	const op = "mqtt-auth.presence.payload"
+
+	now := time.Now()
+	msg := presenceMessage.Message{
+		Type: p.opts.MessageType,
+		Data: presenceMessage.Data{
+			Status:    status,
+			Version:   p.opts.Version,
+			Hostname:  p.opts.Hostname,
+			StartedAt: p.startedAt,
+			Uptime:    int64(now.Sub(p.startedAt).Seconds()),
+			SentAt:    now,
+		},
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to marshal json: %w", op, err)
+	}
+
+	return data, nil
+}
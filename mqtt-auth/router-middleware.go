@@ -0,0 +1,99 @@
+package mqttAuth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	mqttMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/mqtt-message"
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type decodedCtxKey struct{}
+
+func LogMiddleware(log *slog.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		const component = "mqtt-auth/logmw"
+		log := log.With(sl.Component(component))
+
+		return func(ctx context.Context, p *paho.Publish) (err error) {
+			entry := log.With(
+				slog.String("topic", p.Topic),
+				slog.Int("qos", int(p.QoS)),
+				slog.Bool("retain", p.Retain),
+				slog.Int("bytes", len(p.Payload)),
+				sl.MsgID(p),
+			)
+
+			t1 := time.Now()
+			defer func() {
+				entry.Info("message handled",
+					slog.Bool("failed", err != nil),
+					slog.String("duration", time.Since(t1).String()),
+				)
+			}()
+
+			return next(ctx, p)
+		}
+	}
+}
+
+func RecoverMiddleware(log *slog.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		const component = "mqtt-auth/recovermw"
+		log := log.With(sl.Component(component))
+
+		return func(ctx context.Context, p *paho.Publish) (err error) {
+			const op = "mqtt-auth.recovermw"
+
+			defer func() {
+				if rec := recover(); rec != nil {
+					log.Error(
+						"handler panicked",
+						slog.String("topic", p.Topic),
+						slog.String("panic", fmt.Sprint(rec)),
+						slog.String("stack", string(debug.Stack())),
+					)
+					err = fmt.Errorf("%s: handler panicked: %v", op, rec)
+				}
+			}()
+
+			return next(ctx, p)
+		}
+	}
+}
+
+// DecodeMiddleware decodes the payload into mqttMessage.Message[T] and skips
+// messages with a type other than messageType. An empty messageType accepts
+// any type.
+func DecodeMiddleware[T any](log *slog.Logger, messageType string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		const component = "mqtt-auth/decodemw"
+		log := log.With(sl.Component(component))
+
+		return func(ctx context.Context, p *paho.Publish) error {
+			const op = "mqtt-auth.decodemw"
+
+			msg, err := mqttMessage.Decode[T](p)
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+
+			if messageType != "" && msg.Type != messageType {
+				log.Debug("invalid message type, skipping", sl.MsgID(p))
+				return nil
+			}
+
+			msg.Publish = p
+			return next(context.WithValue(ctx, decodedCtxKey{}, msg), p)
+		}
+	}
+}
+
+func Decoded[T any](ctx context.Context) (mqttMessage.Message[T], bool) {
+	msg, ok := ctx.Value(decodedCtxKey{}).(mqttMessage.Message[T])
+	return msg, ok
+}
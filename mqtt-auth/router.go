@@ -1,24 +1,244 @@
 package mqttAuth
 
-import "github.com/eclipse/paho.golang/paho"
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type HandlerFunc func(ctx context.Context, p *paho.Publish) error
+
+// ErrorHandler receives the errors returned by handlers.
+type ErrorHandler func(ctx context.Context, p *paho.Publish, err error)
+
+type Middleware func(next HandlerFunc) HandlerFunc
+
+type HandleOptions struct {
+	// Order sets the position of the handler among the handlers matching
+	// the same message. Handlers with equal order run in registration order.
+	Order       int
+	Middlewares []Middleware
+}
+
+// Router dispatches received messages to every handler whose pattern matches
+// the topic. Patterns are relative to the user scope and may contain MQTT
+// wildcards and named segments, e.g. "pcs/{pcID}/commands". Shared
+// subscription patterns match the topics of their filter.
 type Router struct {
-	*paho.StandardRouter
 	topicFactory *TopicFactory
+
+	mu           sync.RWMutex
+	routes       []*route
+	middlewares  []Middleware
+	seq          int
+	log          *slog.Logger
+	errorHandler ErrorHandler
 }
 
+type route struct {
+	pattern  string
+	segments []string
+	handler  HandlerFunc
+	order    int
+	seq      int
+}
+
+type paramsCtxKey struct{}
+
 func NewRouter(topicFactory *TopicFactory) *Router {
-	router := paho.NewStandardRouter()
+	return &Router{topicFactory: topicFactory, log: slog.Default()}
+}
+
+// SetLogger sets the logger exposed to handlers through Logger.
+func (r *Router) SetLogger(log *slog.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.log = log
+}
 
-	return &Router{StandardRouter: router, topicFactory: topicFactory}
+// SetErrorHandler replaces the default handler which logs returned errors.
+func (r *Router) SetErrorHandler(h ErrorHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errorHandler = h
+}
+
+// Use appends middlewares applied to every handler registered afterwards.
+func (r *Router) Use(mws ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middlewares = append(r.middlewares, mws...)
+}
+
+func (r *Router) Handle(pattern string, h HandlerFunc, opts *HandleOptions) {
+	r.handle(r.topicFactory.Resolve(pattern), h, opts)
+}
+
+// handle registers h for an already resolved pattern.
+func (r *Router) handle(resolved string, h HandlerFunc, opts *HandleOptions) {
+	if opts == nil {
+		opts = &HandleOptions{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, mw := range slices.Backward(opts.Middlewares) {
+		h = mw(h)
+	}
+	for _, mw := range slices.Backward(r.middlewares) {
+		h = mw(h)
+	}
+
+	// shared patterns are told apart by pattern but match their filter
+	r.seq++
+	r.routes = append(r.routes, &route{
+		pattern:  resolved,
+		segments: strings.Split(unsharedTopic(resolved), "/"),
+		handler:  h,
+		order:    opts.Order,
+		seq:      r.seq,
+	})
+
+	slices.SortStableFunc(r.routes, func(a, b *route) int {
+		if a.order != b.order {
+			return a.order - b.order
+		}
+		return a.seq - b.seq
+	})
 }
 
 func (r *Router) RegisterHandler(topic string, h paho.MessageHandler) {
-	userTopic := r.topicFactory.UserTopic(topic)
-	r.StandardRouter.RegisterHandler(userTopic, h)
+	r.Handle(topic, func(_ context.Context, p *paho.Publish) error {
+		h(p)
+		return nil
+	}, nil)
 }
 
+// UnregisterHandler removes every handler registered with the pattern.
 func (r *Router) UnregisterHandler(topic string) {
-	userTopic := r.topicFactory.UserTopic(topic)
-	r.StandardRouter.UnregisterHandler(userTopic)
+	r.unregisterHandler(r.topicFactory.Resolve(topic))
+}
+
+func (r *Router) unregisterHandler(resolved string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = slices.DeleteFunc(r.routes, func(rt *route) bool {
+		return rt.pattern == resolved
+	})
+}
+
+// Route passes p to every matching handler. ctx should be cancelled when
+// the connection that received the message shuts down.
+func (r *Router) Route(ctx context.Context, p *paho.Publish) {
+	r.mu.RLock()
+	routes := slices.Clone(r.routes)
+	log := r.log
+	errorHandler := r.errorHandler
+	r.mu.RUnlock()
+
+	if errorHandler == nil {
+		errorHandler = r.logError
+	}
+
+	ctx = withMessage(ctx, p, log)
+
+	// batch envelopes are routed as the messages they carry
+	messages, err := unbatch(p)
+	if err != nil {
+		errorHandler(ctx, p, err)
+		return
+	}
+
+	topic := strings.Split(p.Topic, "/")
+	for _, msg := range messages {
+		for _, rt := range routes {
+			params, ok := rt.match(topic)
+			if !ok {
+				continue
+			}
+
+			hctx := context.WithValue(ctx, paramsCtxKey{}, params)
+			if err := rt.handler(hctx, msg); err != nil {
+				errorHandler(hctx, msg, err)
+			}
+		}
+	}
+}
+
+func (r *Router) logError(ctx context.Context, _ *paho.Publish, err error) {
+	Logger(ctx).Error("failed to handle message", sl.Err(err))
+}
+
+func (rt *route) match(topic []string) (map[string]string, bool) {
+	params := make(map[string]string)
+
+	// wildcards must not match topics starting with $
+	if len(topic) > 0 && strings.HasPrefix(topic[0], "$") &&
+		len(rt.segments) > 0 && isWildcardSegment(rt.segments[0]) {
+		return nil, false
+	}
+
+	for i, segment := range rt.segments {
+		if segment == "#" {
+			return params, true
+		}
+		if i >= len(topic) {
+			return nil, false
+		}
+
+		switch {
+		case segment == "+":
+		case isParamSegment(segment):
+			params[segment[1:len(segment)-1]] = topic[i]
+		case segment != topic[i]:
+			return nil, false
+		}
+	}
+
+	if len(rt.segments) != len(topic) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// Params returns the named topic segments captured for the current handler.
+func Params(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(paramsCtxKey{}).(map[string]string)
+	return params
+}
+
+// Param returns a single named topic segment or an empty string.
+func Param(ctx context.Context, name string) string {
+	return Params(ctx)[name]
+}
+
+// TopicFilter converts a pattern with named segments into an MQTT filter.
+func TopicFilter(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if isParamSegment(segment) {
+			segments[i] = "+"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func isParamSegment(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+func isWildcardSegment(segment string) bool {
+	return segment == "+" || segment == "#" || isParamSegment(segment)
 }
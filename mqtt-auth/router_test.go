@@ -0,0 +1,128 @@
+package mqttAuth
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		ok      bool
+		params  map[string]string
+	}{
+		{"a/b", "a/b", true, map[string]string{}},
+		{"a/b", "a/c", false, nil},
+		{"a/+", "a/b", true, map[string]string{}},
+		{"a/+", "a/b/c", false, nil},
+		{"a/#", "a/b/c", true, map[string]string{}},
+		{"a/#", "a", true, map[string]string{}},
+		{"#", "$SYS/x", false, nil},
+		{"+/x", "$SYS/x", false, nil},
+		{"$SYS/#", "$SYS/x", true, map[string]string{}},
+		{"pcs/{pcID}/{channel}", "pcs/p1/logs", true, map[string]string{"pcID": "p1", "channel": "logs"}},
+		{"pcs/{pcID}", "pcs/p1/logs", false, nil},
+		{"a/b/c", "a/b", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			rt := &route{segments: strings.Split(tt.pattern, "/")}
+			params, ok := rt.match(strings.Split(tt.topic, "/"))
+			if ok != tt.ok {
+				t.Fatalf("match = %v, want %v", ok, tt.ok)
+			}
+			if ok && !maps.Equal(params, tt.params) {
+				t.Errorf("params = %v, want %v", params, tt.params)
+			}
+		})
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	r := NewRouter(NewTopicFactory("u1"))
+
+	var calls []string
+	record := func(name string) HandlerFunc {
+		return func(ctx context.Context, p *paho.Publish) error {
+			calls = append(calls, name+":"+Param(ctx, "pcID"))
+			return nil
+		}
+	}
+	mw := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, p *paho.Publish) error {
+				calls = append(calls, name)
+				return next(ctx, p)
+			}
+		}
+	}
+
+	r.Use(mw("global"))
+	r.Handle("pcs/{pcID}/commands", record("late"), &HandleOptions{Order: 1})
+	r.Handle("pcs/{pcID}/commands", record("early"), &HandleOptions{
+		Middlewares: []Middleware{mw("route")},
+	})
+	r.Handle("pcs/+/logs", record("logs"), nil)
+	r.Handle(SharedTopic("g", "pcs/{pcID}/commands"), record("shared"), &HandleOptions{Order: 2})
+
+	r.Route(context.Background(), &paho.Publish{Topic: "users/u1/pcs/p1/commands"})
+
+	want := []string{"global", "route", "early:p1", "global", "late:p1", "global", "shared:p1"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	calls = nil
+	r.UnregisterHandler("pcs/{pcID}/commands")
+	r.Route(context.Background(), &paho.Publish{Topic: "users/u1/pcs/p1/commands"})
+
+	want = []string{"global", "shared:p1"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls after unregister = %v, want %v", calls, want)
+	}
+
+	calls = nil
+	r.Route(context.Background(), &paho.Publish{Topic: "users/u2/pcs/p1/commands"})
+	if len(calls) != 0 {
+		t.Errorf("calls for another user = %v, want none", calls)
+	}
+}
+
+func TestRouterErrorHandler(t *testing.T) {
+	r := NewRouter(NewTopicFactory("u1"))
+
+	errHandler := errors.New("handler failed")
+	r.Handle("a", func(context.Context, *paho.Publish) error {
+		return errHandler
+	}, nil)
+
+	var got error
+	var topic string
+	r.SetErrorHandler(func(ctx context.Context, p *paho.Publish, err error) {
+		got = err
+		topic = MessageTopic(ctx)
+	})
+
+	r.Route(context.Background(), &paho.Publish{Topic: "users/u1/a"})
+
+	if !errors.Is(got, errHandler) {
+		t.Errorf("error = %v, want %v", got, errHandler)
+	}
+	if topic != "users/u1/a" {
+		t.Errorf("MessageTopic = %q, want %q", topic, "users/u1/a")
+	}
+}
+
+func TestTopicFilter(t *testing.T) {
+	if got := TopicFilter("pcs/{pcID}/{channel}/#"); got != "pcs/+/+/#" {
+		t.Errorf("TopicFilter = %q, want %q", got, "pcs/+/+/#")
+	}
+}
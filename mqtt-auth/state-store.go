@@ -0,0 +1,390 @@
+package mqttAuth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	mqttMessage "github.com/MaxRomanov007/smart-pc-go-lib/domain/models/mqtt-message"
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	defaultStateQoS     = 1
+	defaultRetainedWait = 500 * time.Millisecond
+	allStateKeys        = "+"
+)
+
+var ErrInvalidStateKey = errors.New("invalid state key")
+
+type StateStoreOptions struct {
+	// Prefix is the topic the keys live under, e.g. "pcs/<pc>/state".
+	Prefix      string
+	MessageType string
+	QoS         byte
+	// RetainedWait bounds how long Get waits for the retained value of a key
+	// it is not watching yet. Defaults to 500ms.
+	RetainedWait time.Duration
+	Log          *slog.Logger
+}
+
+func (o *StateStoreOptions) check() error {
+	errs := make([]error, 0, 3)
+
+	if o.Prefix == "" {
+		errs = append(errs, errors.New("prefix required"))
+	}
+	if o.MessageType == "" {
+		errs = append(errs, errors.New("message type required"))
+	}
+	if o.Log == nil {
+		errs = append(errs, errors.New("log required"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+type StateEvent[T any] struct {
+	Key     string
+	Value   T
+	Deleted bool
+}
+
+type StateHandler[T any] func(StateEvent[T])
+
+// StateStore is a key/value store of device state kept in retained messages,
+// one topic per key under the prefix. Watched keys are cached and every
+// change, local or received, is passed to the OnChange handlers.
+type StateStore[T any] struct {
+	opts       StateStoreOptions
+	connection *Connection
+	router     *Router
+
+	mu       sync.RWMutex
+	entries  map[string]*stateEntry[T]
+	watched  map[string]struct{}
+	waiters  map[string]chan struct{}
+	handlers []StateHandler[T]
+}
+
+type stateEntry[T any] struct {
+	value   T
+	payload []byte
+}
+
+func NewStateStore[T any](
+	connection *Connection,
+	router *Router,
+	opts *StateStoreOptions,
+) (*StateStore[T], error) {
+	const op = "mqtt-auth.state-store.NewStateStore"
+
+	if err := opts.check(); err != nil {
+		return nil, fmt.Errorf("%s: options validate failed: %w", op, err)
+	}
+
+	s := &StateStore[T]{
+		opts:       *opts,
+		connection: connection,
+		router:     router,
+		entries:    make(map[string]*stateEntry[T]),
+		watched:    make(map[string]struct{}),
+		waiters:    make(map[string]chan struct{}),
+	}
+	if s.opts.QoS == 0 {
+		s.opts.QoS = defaultStateQoS
+	}
+	if s.opts.RetainedWait <= 0 {
+		s.opts.RetainedWait = defaultRetainedWait
+	}
+
+	router.Handle(s.topic("{key}"), s.messageHandler(), nil)
+
+	return s, nil
+}
+
+func (s *StateStore[T]) OnChange(h StateHandler[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers = append(s.handlers, h)
+}
+
+// Set publishes value as the retained state of key.
+func (s *StateStore[T]) Set(ctx context.Context, key string, value T) error {
+	const op = "mqtt-auth.state-store.Set"
+
+	if err := checkStateKey(key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	payload, err := json.Marshal(mqttMessage.Message[T]{
+		Type: s.opts.MessageType,
+		Data: value,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal json: %w", op, err)
+	}
+
+	if err := s.publish(ctx, key, payload); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.apply(key, &stateEntry[T]{value: value, payload: payload})
+	return nil
+}
+
+// Delete clears the retained state of key.
+func (s *StateStore[T]) Delete(ctx context.Context, key string) error {
+	const op = "mqtt-auth.state-store.Delete"
+
+	if err := checkStateKey(key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.publish(ctx, key, nil); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.apply(key, nil)
+	return nil
+}
+
+// Get returns the state of key. A key that is not watched yet gets watched,
+// waiting up to RetainedWait for its retained value.
+func (s *StateStore[T]) Get(ctx context.Context, key string) (T, bool, error) {
+	const op = "mqtt-auth.state-store.Get"
+
+	var zero T
+
+	if err := checkStateKey(key); err != nil {
+		return zero, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !s.isWatched(key) {
+		s.mu.Lock()
+		wait, ok := s.waiters[key]
+		if !ok {
+			wait = make(chan struct{})
+			s.waiters[key] = wait
+		}
+		s.mu.Unlock()
+
+		if err := s.Watch(ctx, key); err != nil {
+			return zero, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		timer := time.NewTimer(s.opts.RetainedWait)
+		select {
+		case <-wait:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+
+		s.mu.Lock()
+		if s.waiters[key] == wait {
+			delete(s.waiters, key)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return zero, false, nil
+	}
+
+	return entry.value, true, nil
+}
+
+// Watch subscribes to key, or to every key when key is "+", and keeps its
+// latest value cached.
+func (s *StateStore[T]) Watch(ctx context.Context, key string) error {
+	const op = "mqtt-auth.state-store.Watch"
+
+	if key != allStateKeys {
+		if err := checkStateKey(key); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	s.mu.RLock()
+	_, ok := s.watched[key]
+	s.mu.RUnlock()
+	if ok {
+		return nil
+	}
+
+	if _, err := s.connection.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{
+			{
+				Topic: s.topic(key),
+				QoS:   s.opts.QoS,
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("%s: failed to subscribe on key %q: %w", op, key, err)
+	}
+
+	s.mu.Lock()
+	s.watched[key] = struct{}{}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Unwatch unsubscribes from key and drops its cached value.
+func (s *StateStore[T]) Unwatch(ctx context.Context, key string) error {
+	const op = "mqtt-auth.state-store.Unwatch"
+
+	if _, err := s.connection.Unsubscribe(ctx, &paho.Unsubscribe{
+		Topics: []string{s.topic(key)},
+	}); err != nil {
+		return fmt.Errorf("%s: failed to unsubscribe from key %q: %w", op, key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.watched, key)
+	if key == allStateKeys {
+		for k := range s.entries {
+			if _, ok := s.watched[k]; !ok {
+				delete(s.entries, k)
+			}
+		}
+	} else if _, ok := s.watched[allStateKeys]; !ok {
+		delete(s.entries, key)
+	}
+
+	return nil
+}
+
+// List returns a snapshot of the cached values.
+func (s *StateStore[T]) List() map[string]T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]T, len(s.entries))
+	for key, entry := range s.entries {
+		result[key] = entry.value
+	}
+
+	return result
+}
+
+func (s *StateStore[T]) publish(ctx context.Context, key string, payload []byte) error {
+	if _, err := s.connection.Publish(ctx, &paho.Publish{
+		Topic:   s.topic(key),
+		QoS:     s.opts.QoS,
+		Retain:  true,
+		Payload: payload,
+	}); err != nil {
+		return fmt.Errorf("failed to publish key %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *StateStore[T]) messageHandler() HandlerFunc {
+	return func(ctx context.Context, publish *paho.Publish) error {
+		const op = "mqtt-auth.state-store.messageHandler"
+
+		key := Param(ctx, "key")
+
+		if len(publish.Payload) == 0 {
+			s.apply(key, nil)
+			return nil
+		}
+
+		msg, err := mqttMessage.Decode[T](publish)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if msg.Type != s.opts.MessageType {
+			s.opts.Log.Debug(
+				"invalid message type, skipping",
+				sl.Op(op),
+				slog.String("key", key),
+			)
+			return nil
+		}
+
+		s.apply(key, &stateEntry[T]{value: msg.Data, payload: publish.Payload})
+		return nil
+	}
+}
+
+// apply stores entry, or deletes key when entry is nil, and notifies the
+// handlers if the value changed.
+func (s *StateStore[T]) apply(key string, entry *stateEntry[T]) {
+	s.mu.Lock()
+	if wait, ok := s.waiters[key]; ok {
+		close(wait)
+		delete(s.waiters, key)
+	}
+
+	previous, existed := s.entries[key]
+
+	var event StateEvent[T]
+	switch {
+	case entry == nil && !existed:
+		s.mu.Unlock()
+		return
+	case entry == nil:
+		delete(s.entries, key)
+		event = StateEvent[T]{Key: key, Deleted: true}
+	case existed && bytes.Equal(previous.payload, entry.payload):
+		s.mu.Unlock()
+		return
+	default:
+		s.entries[key] = entry
+		event = StateEvent[T]{Key: key, Value: entry.value}
+	}
+
+	handlers := s.handlers
+	s.mu.Unlock()
+
+	for _, h := range handlers {
+		h(event)
+	}
+}
+
+func (s *StateStore[T]) isWatched(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.watched[key]
+	if !ok {
+		_, ok = s.watched[allStateKeys]
+	}
+
+	return ok
+}
+
+func (s *StateStore[T]) topic(key string) string {
+	return s.opts.Prefix + "/" + key
+}
+
+func checkStateKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "/+#") {
+		return fmt.Errorf("%q: %w", key, ErrInvalidStateKey)
+	}
+
+	return nil
+}
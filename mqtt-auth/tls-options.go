@@ -0,0 +1,251 @@
+package mqttAuth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSOptions configures tls:// and wss:// connections. Certificates can be
+// given as files or PEM bytes; files are re-read on the next handshake after
+// they change, so renewed certificates are picked up on reconnect.
+type TLSOptions struct {
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	CAPEM   []byte `yaml:"-"`
+	CertPEM []byte `yaml:"-"`
+	KeyPEM  []byte `yaml:"-"`
+
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (o *TLSOptions) validate() error {
+	var errs []error
+
+	if o.CAFile != "" && len(o.CAPEM) > 0 {
+		errs = append(errs, errors.New("tls: ca file and ca pem are mutually exclusive"))
+	}
+	if (o.CertFile != "" || o.KeyFile != "") && (len(o.CertPEM) > 0 || len(o.KeyPEM) > 0) {
+		errs = append(errs, errors.New("tls: cert files and cert pem are mutually exclusive"))
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert file and key file must be set together"))
+	}
+	if (len(o.CertPEM) == 0) != (len(o.KeyPEM) == 0) {
+		errs = append(errs, errors.New("tls: cert pem and key pem must be set together"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (o *TLSOptions) hasCA() bool {
+	return o.CAFile != "" || len(o.CAPEM) > 0
+}
+
+func (o *TLSOptions) hasCert() bool {
+	return o.CertFile != "" || len(o.CertPEM) > 0
+}
+
+// tlsConfig loads the certificates once to fail fast on bad input and
+// returns a config that keeps them up to date. servers are the broker urls
+// the certificate is verified against when they are IP addresses.
+func (o *TLSOptions) tlsConfig(servers []*url.URL) (*tls.Config, error) {
+	const op = "mqtt-auth.tls-options.tlsConfig"
+
+	l := &tlsLoader{opts: *o}
+	for _, u := range servers {
+		if host := u.Hostname(); net.ParseIP(host) != nil {
+			l.ipHosts = append(l.ipHosts, host)
+		}
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.hasCA() {
+		if _, err := l.caPool(); err != nil {
+			return nil, fmt.Errorf("%s: failed to load ca: %w", op, err)
+		}
+
+		// the pool may change between handshakes, so verification is done
+		// manually against the current one
+		cfg.InsecureSkipVerify = true
+		if !o.InsecureSkipVerify {
+			cfg.VerifyConnection = l.verifyConnection
+		}
+	}
+
+	if o.hasCert() {
+		if _, err := l.certificate(); err != nil {
+			return nil, fmt.Errorf("%s: failed to load client certificate: %w", op, err)
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return l.certificate()
+		}
+	}
+
+	return cfg, nil
+}
+
+type tlsLoader struct {
+	opts    TLSOptions
+	ipHosts []string
+
+	mu         sync.Mutex
+	pool       *x509.CertPool
+	caModTime  time.Time
+	cert       *tls.Certificate
+	certModKey [2]time.Time
+}
+
+func (l *tlsLoader) caPool() (*x509.CertPool, error) {
+	const op = "mqtt-auth.tls-options.caPool"
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data := l.opts.CAPEM
+	if l.opts.CAFile != "" {
+		modTime, err := modTime(l.opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if l.pool != nil && modTime.Equal(l.caModTime) {
+			return l.pool, nil
+		}
+
+		data, err = os.ReadFile(l.opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read ca file: %w", op, err)
+		}
+		l.caModTime = modTime
+	} else if l.pool != nil {
+		return l.pool, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates found in ca", op)
+	}
+
+	l.pool = pool
+	return pool, nil
+}
+
+func (l *tlsLoader) certificate() (*tls.Certificate, error) {
+	const op = "mqtt-auth.tls-options.certificate"
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	certPEM, keyPEM := l.opts.CertPEM, l.opts.KeyPEM
+	if l.opts.CertFile != "" {
+		certModTime, err := modTime(l.opts.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keyModTime, err := modTime(l.opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		modKey := [2]time.Time{certModTime, keyModTime}
+		if l.cert != nil && modKey == l.certModKey {
+			return l.cert, nil
+		}
+
+		if certPEM, err = os.ReadFile(l.opts.CertFile); err != nil {
+			return nil, fmt.Errorf("%s: failed to read cert file: %w", op, err)
+		}
+		if keyPEM, err = os.ReadFile(l.opts.KeyFile); err != nil {
+			return nil, fmt.Errorf("%s: failed to read key file: %w", op, err)
+		}
+		l.certModKey = modKey
+	} else if l.cert != nil {
+		return l.cert, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to parse key pair: %w", op, err)
+	}
+
+	l.cert = &cert
+	return l.cert, nil
+}
+
+func (l *tlsLoader) verifyConnection(cs tls.ConnectionState) error {
+	const op = "mqtt-auth.tls-options.verifyConnection"
+
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("%s: server sent no certificates", op)
+	}
+
+	pool, err := l.caPool()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := cs.PeerCertificates[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+	}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	names := l.serverNames(cs)
+	if len(names) == 0 {
+		return fmt.Errorf("%s: no server name to verify the certificate against", op)
+	}
+
+	var errs []error
+	for _, name := range names {
+		err := leaf.VerifyHostname(name)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	return fmt.Errorf("%s: %w", op, errors.Join(errs...))
+}
+
+// serverNames returns the names the broker certificate may be issued for.
+// The SNI carries the dialed host, but IP addresses are left out of it, so
+// without one the certificate must match one of the IP broker urls.
+func (l *tlsLoader) serverNames(cs tls.ConnectionState) []string {
+	if l.opts.ServerName != "" {
+		return []string{l.opts.ServerName}
+	}
+	if cs.ServerName != "" {
+		return []string{cs.ServerName}
+	}
+
+	return l.ipHosts
+}
+
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	return info.ModTime(), nil
+}
@@ -0,0 +1,298 @@
+package mqttAuth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// authority is a test CA issuing broker and client certificates.
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T) *authority {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse ca: %v", err)
+	}
+
+	return &authority{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns the PEM certificate and key of a leaf for the given name,
+// an IP address or a DNS name.
+func (a *authority) issue(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// broker is a TLS listener presenting a certificate for name and, with
+// clientCAs, requiring a client certificate. It reports the common name of
+// the client certificate of every handshake.
+func broker(t *testing.T, a *authority, name string, clientCAs *authority) (addr string, clients <-chan string) {
+	t.Helper()
+
+	certPEM, keyPEM := a.issue(t, name)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAs != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCAs.cert)
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	ch := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if tlsConn.Handshake() == nil {
+				if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) > 0 {
+					ch <- peers[0].Subject.CommonName
+				}
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return ln.Addr().String(), ch
+}
+
+// dial handshakes with the broker at addr the way autopaho does for a
+// tls:// url with the given host.
+func dial(t *testing.T, opts *TLSOptions, host, addr string) error {
+	t.Helper()
+
+	u := &url.URL{Scheme: "tls", Host: host}
+	cfg, err := opts.tlsConfig([]*url.URL{u})
+	if err != nil {
+		t.Fatalf("tlsConfig: %v", err)
+	}
+	if host != "127.0.0.1" {
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+
+	d := tls.Dialer{Config: cfg}
+	conn, err := d.DialContext(t.Context(), "tcp", addr)
+	if err != nil {
+		return err
+	}
+	// the client sends its certificate in the handshake, but TLS 1.3 lets
+	// the server reject it only after the client finished
+	_, err = conn.Read(make([]byte, 1))
+	_ = conn.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+func TestTLSOptionsVerify(t *testing.T) {
+	ca := newAuthority(t)
+	other := newAuthority(t)
+
+	tests := []struct {
+		name       string
+		ca         *authority
+		issuedFor  string
+		host       string
+		serverName string
+		ok         bool
+	}{
+		{"ip", ca, "127.0.0.1", "127.0.0.1", "", true},
+		{"ip not in certificate", ca, "broker.test", "127.0.0.1", "", false},
+		{"ip with server name", ca, "broker.test", "127.0.0.1", "broker.test", true},
+		{"wrong server name", ca, "127.0.0.1", "127.0.0.1", "other.test", false},
+		{"dns name", ca, "broker.test", "broker.test", "", true},
+		{"dns name not in certificate", ca, "other.test", "broker.test", "", false},
+		{"unknown authority", other, "127.0.0.1", "127.0.0.1", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := broker(t, tt.ca, tt.issuedFor, nil)
+
+			err := dial(t, &TLSOptions{CAPEM: ca.pem, ServerName: tt.serverName}, tt.host, addr)
+			if (err == nil) != tt.ok {
+				t.Errorf("handshake error = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTLSOptionsVerifyWithoutName(t *testing.T) {
+	ca := newAuthority(t)
+	addr, _ := broker(t, ca, "127.0.0.1", nil)
+
+	// without a broker url there is nothing to check the certificate against
+	cfg, err := (&TLSOptions{CAPEM: ca.pem}).tlsConfig(nil)
+	if err != nil {
+		t.Fatalf("tlsConfig: %v", err)
+	}
+	d := tls.Dialer{Config: cfg}
+	if conn, err := d.DialContext(t.Context(), "tcp", addr); err == nil {
+		_ = conn.Close()
+		t.Error("handshake succeeded without a server name")
+	}
+}
+
+func TestTLSOptionsClientCertificate(t *testing.T) {
+	ca := newAuthority(t)
+	addr, clients := broker(t, ca, "127.0.0.1", ca)
+
+	if err := dial(t, &TLSOptions{CAPEM: ca.pem}, "127.0.0.1", addr); err == nil {
+		t.Error("handshake succeeded without a client certificate")
+	}
+
+	certPEM, keyPEM := ca.issue(t, "device")
+	if err := dial(t, &TLSOptions{CAPEM: ca.pem, CertPEM: certPEM, KeyPEM: keyPEM}, "127.0.0.1", addr); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if name := <-clients; name != "device" {
+		t.Errorf("client certificate of %q, want device", name)
+	}
+}
+
+func TestTLSOptionsReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte, modTime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("chtimes %s: %v", name, err)
+		}
+		return path
+	}
+
+	oldCA, newCA := newAuthority(t), newAuthority(t)
+	past := time.Now().Add(-time.Minute)
+
+	certPEM, keyPEM := oldCA.issue(t, "old device")
+	opts := &TLSOptions{
+		CAFile:   write("ca.pem", oldCA.pem, past),
+		CertFile: write("cert.pem", certPEM, past),
+		KeyFile:  write("key.pem", keyPEM, past),
+	}
+	cfg, err := opts.tlsConfig([]*url.URL{{Scheme: "tls", Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("tlsConfig: %v", err)
+	}
+	handshake := func(addr string) error {
+		d := tls.Dialer{Config: cfg}
+		conn, err := d.DialContext(t.Context(), "tcp", addr)
+		if err == nil {
+			_, _ = conn.Read(make([]byte, 1))
+			_ = conn.Close()
+		}
+		return err
+	}
+
+	oldAddr, oldClients := broker(t, oldCA, "127.0.0.1", oldCA)
+	if err := handshake(oldAddr); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if name := <-oldClients; name != "old device" {
+		t.Errorf("client certificate of %q, want old device", name)
+	}
+
+	// the files are renewed in place, the same config picks them up
+	certPEM, keyPEM = newCA.issue(t, "new device")
+	write("ca.pem", newCA.pem, time.Now())
+	write("cert.pem", certPEM, time.Now())
+	write("key.pem", keyPEM, time.Now())
+
+	if err := handshake(oldAddr); err == nil {
+		t.Error("handshake with the old authority succeeded after the ca was replaced")
+	}
+	newAddr, newClients := broker(t, newCA, "127.0.0.1", newCA)
+	if err := handshake(newAddr); err != nil {
+		t.Fatalf("handshake after reload: %v", err)
+	}
+	if name := <-newClients; name != "new device" {
+		t.Errorf("client certificate of %q, want new device", name)
+	}
+}
@@ -1,17 +1,224 @@
 package mqttAuth
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
 
-const UsersTopic = "users"
+const (
+	UsersTopic = "users"
+	PcsTopic   = "pcs"
+	SystemRoot = "system"
+	// SharePrefix starts MQTT v5 shared subscription filters.
+	SharePrefix = "$share"
+	// AbsolutePrefix marks topics Resolve passes to the broker without the
+	// user scope, see AbsoluteTopic.
+	AbsolutePrefix = "/"
+)
+
+const (
+	ChannelCommands = "commands"
+	ChannelLogs     = "logs"
+	ChannelStatus   = "status"
+)
+
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// Topic is a parsed topic name of one of the forms:
+//
+//	users/<user>/pcs/<pc>[/<channel>[/<rest>]]
+//	users/<user>/<rest>
+//	system/<rest>
+type Topic struct {
+	UserID  string
+	PcID    string
+	Channel string
+	Rest    string
+	System  bool
+}
 
 type TopicFactory struct {
 	userID string
 }
 
+// NewTopicFactory returns a factory scoped to userID. An empty userID gives
+// an unscoped factory, used by service connections, that resolves topics
+// as is.
 func NewTopicFactory(userID string) *TopicFactory {
 	return &TopicFactory{userID: userID}
 }
 
+func (f *TopicFactory) UserID() string {
+	return f.userID
+}
+
 func (f *TopicFactory) UserTopic(topic string) string {
 	return fmt.Sprintf("%s/%s/%s", UsersTopic, f.userID, topic)
 }
+
+// PcTopic returns the user relative topic of a PC channel,
+// e.g. "pcs/<pc>/commands".
+func PcTopic(pcID, channel string) string {
+	return fmt.Sprintf("%s/%s/%s", PcsTopic, pcID, channel)
+}
+
+// SystemTopic returns a topic shared by all users, it is never prefixed
+// with the user scope.
+func SystemTopic(topic string) string {
+	return AbsoluteTopic(SystemRoot + "/" + topic)
+}
+
+// AbsoluteTopic marks topic, a broker topic, so Resolve keeps it as is
+// instead of putting it into the user scope.
+func AbsoluteTopic(topic string) string {
+	return AbsolutePrefix + topic
+}
+
+// SharedTopic returns the shared subscription filter of topic, so only one
+// subscriber of group receives each message.
+func SharedTopic(group, topic string) string {
+	return fmt.Sprintf("%s/%s/%s", SharePrefix, group, topic)
+}
+
+// Resolve turns a relative topic into the broker topic: absolute topics
+// lose their marker, everything else goes to the user scope. Shared
+// subscriptions get the user scope inside the share name.
+//
+// A leading AbsolutePrefix "/" marks an absolute topic, so "/a" resolves to
+// "a" rather than to "users/<user>//a". Broker topics starting with an
+// empty level can not be resolved.
+func (f *TopicFactory) Resolve(topic string) string {
+	if group, filter, ok := SplitSharedTopic(topic); ok {
+		return SharedTopic(group, f.Resolve(filter))
+	}
+
+	if absolute, ok := strings.CutPrefix(topic, AbsolutePrefix); ok {
+		return absolute
+	}
+
+	if f.userID == "" {
+		return topic
+	}
+
+	return f.UserTopic(topic)
+}
+
+// Format builds the broker topic from its parts, the inverse of ParseTopic.
+// The user defaults to the one of f. Parts not fitting any topic form, e.g.
+// a channel without a PC, and a user, PC or channel holding a level
+// separator or a wildcard fail with ErrInvalidTopic.
+func (f *TopicFactory) Format(t *Topic) (string, error) {
+	const op = "mqtt-auth.topic-factory.Format"
+
+	if t.System {
+		if t.Rest == "" || t.UserID != "" || t.PcID != "" || t.Channel != "" {
+			return "", fmt.Errorf("%s: system topic %+v: %w", op, *t, ErrInvalidTopic)
+		}
+		return SystemRoot + "/" + t.Rest, nil
+	}
+
+	userID := t.UserID
+	if userID == "" {
+		userID = f.userID
+	}
+
+	switch {
+	case userID == "":
+		return "", fmt.Errorf("%s: no user: %w", op, ErrInvalidTopic)
+	case t.PcID == "" && t.Channel != "":
+		return "", fmt.Errorf("%s: channel %q without pc: %w", op, t.Channel, ErrInvalidTopic)
+	case t.PcID == "" && t.Rest == "":
+		return "", fmt.Errorf("%s: neither pc nor rest: %w", op, ErrInvalidTopic)
+	case t.PcID != "" && t.Channel == "" && t.Rest != "":
+		return "", fmt.Errorf("%s: rest %q without channel: %w", op, t.Rest, ErrInvalidTopic)
+	}
+	for _, level := range []string{userID, t.PcID, t.Channel} {
+		if strings.ContainsAny(level, "/+#") {
+			return "", fmt.Errorf("%s: level %q is not a single level: %w", op, level, ErrInvalidTopic)
+		}
+	}
+
+	segments := []string{UsersTopic, userID}
+	if t.PcID != "" {
+		segments = append(segments, PcsTopic, t.PcID)
+		if t.Channel != "" {
+			segments = append(segments, t.Channel)
+		}
+	}
+	if t.Rest != "" {
+		segments = append(segments, t.Rest)
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// ParseTopic extracts the user, PC and channel of a received topic. Topics
+// with empty levels, e.g. "users/<user>/", are invalid.
+func ParseTopic(topic string) (*Topic, error) {
+	const op = "mqtt-auth.topic-factory.ParseTopic"
+
+	if slices.Contains(strings.Split(topic, "/"), "") {
+		return nil, fmt.Errorf("%s: %q: %w", op, topic, ErrInvalidTopic)
+	}
+
+	if rest, ok := strings.CutPrefix(topic, SystemRoot+"/"); ok {
+		return &Topic{System: true, Rest: rest}, nil
+	}
+
+	segments := strings.SplitN(topic, "/", 6)
+	if len(segments) < 3 || segments[0] != UsersTopic {
+		return nil, fmt.Errorf("%s: %q: %w", op, topic, ErrInvalidTopic)
+	}
+
+	t := &Topic{UserID: segments[1]}
+	if segments[2] != PcsTopic {
+		t.Rest = strings.Join(segments[2:], "/")
+		return t, nil
+	}
+
+	if len(segments) < 4 {
+		return nil, fmt.Errorf("%s: %q: %w", op, topic, ErrInvalidTopic)
+	}
+
+	t.PcID = segments[3]
+	if len(segments) > 4 {
+		t.Channel = segments[4]
+	}
+	if len(segments) == 6 {
+		t.Rest = segments[5]
+	}
+
+	return t, nil
+}
+
+// SplitSharedTopic returns the group and the filter of a
+// "$share/<group>/<filter>" subscription.
+func SplitSharedTopic(topic string) (group, filter string, ok bool) {
+	rest, ok := strings.CutPrefix(topic, SharePrefix+"/")
+	if !ok {
+		return "", "", false
+	}
+
+	group, filter, ok = strings.Cut(rest, "/")
+	if !ok || group == "" || filter == "" {
+		return "", "", false
+	}
+
+	return group, filter, true
+}
+
+// unsharedTopic strips the share name, leaving the filter messages are
+// actually published on.
+func unsharedTopic(topic string) string {
+	if _, filter, ok := SplitSharedTopic(topic); ok {
+		return filter
+	}
+
+	return topic
+}
+
+func (f *TopicFactory) trimUserTopic(topic string) (string, bool) {
+	return strings.CutPrefix(topic, f.UserTopic(""))
+}
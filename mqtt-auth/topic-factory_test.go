@@ -0,0 +1,161 @@
+package mqttAuth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTopicFactoryResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		topic  string
+		want   string
+	}{
+		{"relative", "u1", "pcs/p1/commands", "users/u1/pcs/p1/commands"},
+		{"relative system level", "u1", "system/x", "users/u1/system/x"},
+		{"relative dollar", "u1", "$SYS/x", "users/u1/$SYS/x"},
+		{"absolute", "u1", AbsoluteTopic("a/b"), "a/b"},
+		{"system", "u1", SystemTopic("announcements"), "system/announcements"},
+		{"shared", "u1", SharedTopic("g", "pcs/+/commands"), "$share/g/users/u1/pcs/+/commands"},
+		{"shared system", "u1", SharedTopic("g", SystemTopic("jobs")), "$share/g/system/jobs"},
+		{"unscoped factory", "", "users/u2/pcs/p1/logs", "users/u2/pcs/p1/logs"},
+		{"unscoped factory absolute", "", AbsoluteTopic("a"), "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewTopicFactory(tt.userID).Resolve(tt.topic); got != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  Topic
+	}{
+		{"users/u1/pcs/p1/commands", Topic{UserID: "u1", PcID: "p1", Channel: "commands"}},
+		{"users/u1/pcs/p1/logs/a/b", Topic{UserID: "u1", PcID: "p1", Channel: "logs", Rest: "a/b"}},
+		{"users/u1/pcs/p1", Topic{UserID: "u1", PcID: "p1"}},
+		{"users/u1/settings/theme", Topic{UserID: "u1", Rest: "settings/theme"}},
+		{"system/announcements", Topic{System: true, Rest: "announcements"}},
+	}
+
+	f := NewTopicFactory("u1")
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			got, err := ParseTopic(tt.topic)
+			if err != nil {
+				t.Fatalf("ParseTopic(%q): %v", tt.topic, err)
+			}
+			if *got != tt.want {
+				t.Errorf("ParseTopic(%q) = %+v, want %+v", tt.topic, *got, tt.want)
+			}
+			if formatted, err := f.Format(got); err != nil || formatted != tt.topic {
+				t.Errorf("Format(%+v) = %q, %v, want %q", *got, formatted, err, tt.topic)
+			}
+		})
+	}
+}
+
+func TestParseTopicInvalid(t *testing.T) {
+	for _, topic := range []string{
+		"",
+		"users",
+		"users//pcs/p1",
+		"other/u1/x",
+		"users/u1/pcs",
+		"users/u1/pcs/",
+		"users/u1/pcs/p1/",
+		"users/u1/",
+		"users/u1/pcs/p1/logs/",
+		"users/u1/settings//theme",
+		"system/",
+		"system//x",
+	} {
+		t.Run(topic, func(t *testing.T) {
+			if _, err := ParseTopic(topic); !errors.Is(err, ErrInvalidTopic) {
+				t.Errorf("ParseTopic(%q) error = %v, want %v", topic, err, ErrInvalidTopic)
+			}
+		})
+	}
+}
+
+func TestTopicFactoryFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic Topic
+		want  string
+	}{
+		{"factory user", Topic{PcID: "p1", Channel: "logs"}, "users/u1/pcs/p1/logs"},
+		{"other user", Topic{UserID: "u2", Rest: "settings"}, "users/u2/settings"},
+		{"channel without pc", Topic{Channel: "logs"}, ""},
+		{"channel and rest without pc", Topic{Channel: "logs", Rest: "a"}, ""},
+		{"rest without channel", Topic{PcID: "p1", Rest: "a"}, ""},
+		{"user only", Topic{UserID: "u2"}, ""},
+		{"system without rest", Topic{System: true}, ""},
+		{"system with pc", Topic{System: true, PcID: "p1", Rest: "a"}, ""},
+		{"slash in user", Topic{UserID: "u2/x", Rest: "settings"}, ""},
+		{"plus in user", Topic{UserID: "+", Rest: "settings"}, ""},
+		{"hash in user", Topic{UserID: "#", Rest: "settings"}, ""},
+		{"slash in pc", Topic{PcID: "p1/x"}, ""},
+		{"plus in pc", Topic{PcID: "+", Channel: "logs"}, ""},
+		{"hash in pc", Topic{PcID: "p#"}, ""},
+		{"slash in channel", Topic{PcID: "p1", Channel: "logs/x"}, ""},
+		{"plus in channel", Topic{PcID: "p1", Channel: "+"}, ""},
+		{"hash in channel", Topic{PcID: "p1", Channel: "#"}, ""},
+		{"levels in rest", Topic{PcID: "p1", Channel: "logs", Rest: "a/b"}, "users/u1/pcs/p1/logs/a/b"},
+	}
+
+	f := NewTopicFactory("u1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.Format(&tt.topic)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidTopic) {
+					t.Errorf("Format(%+v) = %q, %v, want %v", tt.topic, got, err, ErrInvalidTopic)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Format(%+v) = %q, %v, want %q", tt.topic, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewTopicFactory("").Format(&Topic{PcID: "p1"}); !errors.Is(err, ErrInvalidTopic) {
+		t.Errorf("Format without any user error = %v, want %v", err, ErrInvalidTopic)
+	}
+	if _, err := NewTopicFactory("u1/x").Format(&Topic{PcID: "p1"}); !errors.Is(err, ErrInvalidTopic) {
+		t.Errorf("Format with a multi-level factory user error = %v, want %v", err, ErrInvalidTopic)
+	}
+}
+
+func TestSplitSharedTopic(t *testing.T) {
+	tests := []struct {
+		topic         string
+		group, filter string
+		ok            bool
+	}{
+		{"$share/g/a/b", "g", "a/b", true},
+		{"$share/g/", "", "", false},
+		{"$share//a", "", "", false},
+		{"$share/g", "", "", false},
+		{"a/b", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			group, filter, ok := SplitSharedTopic(tt.topic)
+			if group != tt.group || filter != tt.filter || ok != tt.ok {
+				t.Errorf(
+					"SplitSharedTopic(%q) = %q, %q, %v, want %q, %q, %v",
+					tt.topic, group, filter, ok, tt.group, tt.filter, tt.ok,
+				)
+			}
+		})
+	}
+}
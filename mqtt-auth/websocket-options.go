@@ -0,0 +1,74 @@
+package mqttAuth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/MaxRomanov007/smart-pc-go-lib/logger/sl"
+	"github.com/eclipse/paho.golang/autopaho"
+)
+
+// WebSocketOptions configures ws:// and wss:// connections.
+type WebSocketOptions struct {
+	// TokenInHeader sends the OAuth token in the Authorization header of the
+	// upgrade request instead of the CONNECT password. The token is fetched
+	// before every connection attempt, so reconnects use a fresh one.
+	TokenInHeader bool        `yaml:"token_in_header"`
+	Header        http.Header `yaml:"header"`
+}
+
+func (o *WebSocketOptions) validate(serverURLs []string) error {
+	if !o.TokenInHeader {
+		return nil
+	}
+
+	for _, raw := range serverURLs {
+		u, err := parseServerURL(raw)
+		if err != nil {
+			return fmt.Errorf("websocket: %w", err)
+		}
+		if u.Scheme != "ws" && u.Scheme != "wss" {
+			return errors.New("websocket: token in header requires ws or wss server urls")
+		}
+	}
+
+	return nil
+}
+
+func (o *WebSocketOptions) webSocketConfig(
+	ctx context.Context,
+	tokens TokenProvider,
+	log *slog.Logger,
+) *autopaho.WebSocketConfig {
+	const component = "mqtt-auth/websocket"
+	log = log.With(sl.Component(component))
+
+	return &autopaho.WebSocketConfig{
+		Header: func(*url.URL, *tls.Config) http.Header {
+			header := o.Header.Clone()
+			if header == nil {
+				header = make(http.Header)
+			}
+
+			if !o.TokenInHeader {
+				return header
+			}
+
+			// the upgrade is rejected without the header, and autopaho
+			// retries with a new token on the next attempt
+			token, err := tokens.Token(ctx)
+			if err != nil {
+				log.Error("failed to get token for the upgrade request", sl.Err(err))
+				return header
+			}
+
+			header.Set("Authorization", "Bearer "+token)
+			return header
+		},
+	}
+}
@@ -0,0 +1,138 @@
+package mqttAuth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// tokenSequence hands out a new token on every call, or err.
+type tokenSequence struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (s *tokenSequence) Token(context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.err != nil {
+		return "", s.err
+	}
+	return fmt.Sprintf("token-%d", s.calls), nil
+}
+
+func TestWebSocketHeader(t *testing.T) {
+	u := &url.URL{Scheme: "wss", Host: "broker"}
+	log := slog.New(slog.DiscardHandler)
+	opts := &WebSocketOptions{
+		TokenInHeader: true,
+		Header:        http.Header{"X-Device": {"pc"}},
+	}
+
+	tokens := new(tokenSequence)
+	cfg := opts.webSocketConfig(t.Context(), tokens, log)
+
+	// every connection attempt gets a fresh token
+	for i := 1; i <= 2; i++ {
+		header := cfg.Header(u, nil)
+		if got, want := header.Get("Authorization"), fmt.Sprintf("Bearer token-%d", i); got != want {
+			t.Errorf("attempt %d: Authorization = %q, want %q", i, got, want)
+		}
+		if header.Get("X-Device") != "pc" {
+			t.Errorf("attempt %d: header = %v, want X-Device kept", i, header)
+		}
+	}
+	if opts.Header.Get("Authorization") != "" {
+		t.Errorf("configured header modified: %v", opts.Header)
+	}
+
+	failing := &tokenSequence{err: errors.New("offline")}
+	if header := opts.webSocketConfig(t.Context(), failing, log).Header(u, nil); header.Get("Authorization") != "" {
+		t.Errorf("Authorization = %q without a token", header.Get("Authorization"))
+	}
+
+	unused := new(tokenSequence)
+	plain := &WebSocketOptions{Header: opts.Header}
+	if header := plain.webSocketConfig(t.Context(), unused, log).Header(u, nil); header.Get("Authorization") != "" || unused.calls != 0 {
+		t.Errorf("Authorization = %q after %d token calls, want none", header.Get("Authorization"), unused.calls)
+	}
+}
+
+func TestConnectPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		ws       *WebSocketOptions
+		password string
+	}{
+		{"mqtt", "mqtt://broker:1883", nil, "token-1"},
+		{"websocket", "ws://broker/mqtt", &WebSocketOptions{}, "token-1"},
+		{"token in header", "ws://broker/mqtt", &WebSocketOptions{TokenInHeader: true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := new(tokenSequence)
+			cfg, err := NewServiceClientConfig(t.Context(), tokens, "svc", &Options{
+				ServerURLs: []string{tt.server},
+				ClientID:   "svc",
+				WebSocket:  tt.ws,
+			})
+			if err != nil {
+				t.Fatalf("NewServiceClientConfig: %v", err)
+			}
+
+			u, _ := url.Parse(tt.server)
+			connect, err := cfg.ConnectPacketBuilder(&paho.Connect{}, u)
+			if err != nil {
+				t.Fatalf("ConnectPacketBuilder: %v", err)
+			}
+			if connect.PasswordFlag != (tt.password != "") || string(connect.Password) != tt.password {
+				t.Errorf("password = %q (flag %v), want %q", connect.Password, connect.PasswordFlag, tt.password)
+			}
+			if !connect.UsernameFlag || connect.Username != "svc" {
+				t.Errorf("username = %q, want svc", connect.Username)
+			}
+		})
+	}
+}
+
+func TestWebSocketValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		want   string
+	}{
+		{"ws", "ws://broker/mqtt", ""},
+		{"wss", "wss://broker/mqtt", ""},
+		{"mqtt", "mqtt://broker:1883", "requires ws or wss"},
+		{"unparsable", "ws://[::1", "invalid server url"},
+		{"no host", "ws:///mqtt", "has no host"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&WebSocketOptions{TokenInHeader: true}).validate([]string{tt.server})
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}